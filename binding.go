@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Allocation modes for a tunnel's local address
+const (
+	AllocateLoopback = "loopback" // Dedicated loopback IP (127.0.0.2, 127.0.0.3, ...) on the original port
+	AllocatePort     = "port"     // Free port chosen by the manager
+)
+
+// Loopback addresses handed out by AllocateLoopback. 127.0.0.1 is left to local services.
+const (
+	firstLoopbackHost = 2
+	lastLoopbackHost  = 254
+)
+
+// validateAllocation checks the allocation mode and the bind address
+func validateAllocation(config TunnelConfig) error {
+	switch config.Allocate {
+	case "", AllocateLoopback, AllocatePort:
+	default:
+		return fmt.Errorf("unknown allocation mode %q", config.Allocate)
+	}
+
+	switch config.BindAddress {
+	case "", "localhost", "*":
+		return nil
+	}
+	if net.ParseIP(config.BindAddress) == nil {
+		return fmt.Errorf("bind address %q must be an IP address, localhost or *", config.BindAddress)
+	}
+	return nil
+}
+
+// allocateBinding assigns a dedicated loopback address or a free port to the tunnel.
+// The value allocated earlier, recorded in previous, is kept so addresses stay stable
+// across edits and restarts, unless it now conflicts with another tunnel or with what
+// the user changed. The caller must hold tm.mutex.
+func (tm *TunnelManager) allocateBinding(config *TunnelConfig, previous *TunnelConfig) error {
+	if previous != nil && previous.Allocate == config.Allocate {
+		addresses, ports := tm.usedBindings(config.Name)
+
+		// Only the allocated field is carried over; the other one is the user's to edit.
+		// The tunnel may hold its old binding, so bind only when it changed.
+		switch config.Allocate {
+		case AllocateLoopback:
+			config.BindAddress = previous.BindAddress
+			unchanged := config.LocalPort == previous.LocalPort
+			if !addresses[config.BindAddress] && (unchanged || isBindAvailable(config.BindAddress, config.LocalPort)) {
+				return nil
+			}
+		case AllocatePort:
+			config.LocalPort = previous.LocalPort
+			unchanged := config.BindAddress == previous.BindAddress
+			if !ports[config.LocalPort] && (unchanged || isBindAvailable(config.BindAddress, config.LocalPort)) {
+				return nil
+			}
+		}
+	}
+
+	switch config.Allocate {
+	case AllocateLoopback:
+		address, err := tm.freeLoopbackAddress(config.Name, config.LocalPort)
+		if err != nil {
+			return err
+		}
+		config.BindAddress = address
+
+	case AllocatePort:
+		port, err := tm.freeTunnelPort(config.Name, config.BindAddress)
+		if err != nil {
+			return err
+		}
+		config.LocalPort = port
+	}
+	return nil
+}
+
+// usedBindings returns the bind addresses and local ports configured by every tunnel
+// except name
+func (tm *TunnelManager) usedBindings(name string) (addresses, ports map[string]bool) {
+	addresses = make(map[string]bool)
+	ports = make(map[string]bool)
+	for _, tunnel := range tm.tunnels {
+		if tunnel.config.Name != name {
+			addresses[tunnel.config.BindAddress] = true
+			ports[tunnel.config.LocalPort] = true
+		}
+	}
+	return addresses, ports
+}
+
+// freeLoopbackAddress returns a loopback IP no other tunnel uses on which port can be bound
+func (tm *TunnelManager) freeLoopbackAddress(name, port string) (string, error) {
+	used, _ := tm.usedBindings(name)
+
+	for host := firstLoopbackHost; host <= lastLoopbackHost; host++ {
+		address := fmt.Sprintf("127.0.0.%d", host)
+		if used[address] {
+			continue
+		}
+		if isBindAvailable(address, port) {
+			return address, nil
+		}
+		// Only 127.0.0.1 exists on macOS until aliases are added
+		if !canBindAddress(address) {
+			return "", fmt.Errorf("cannot bind %s; on macOS add loopback aliases first (sudo ifconfig lo0 alias %s)", address, address)
+		}
+	}
+	return "", fmt.Errorf("no free loopback address for port %s", port)
+}
+
+// freeTunnelPort asks the kernel for a free port that no other tunnel is configured with
+func (tm *TunnelManager) freeTunnelPort(name, host string) (string, error) {
+	_, used := tm.usedBindings(name)
+
+	for i := 0; i < 10; i++ {
+		ln, err := net.Listen("tcp", net.JoinHostPort(listenHost(host), "0"))
+		if err != nil {
+			return "", fmt.Errorf("cannot allocate a port: %v", err)
+		}
+		port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+		ln.Close()
+
+		if !used[port] && isBindAvailable(host, port) {
+			return port, nil
+		}
+	}
+	return "", fmt.Errorf("cannot allocate a free port")
+}
+
+// extractLocalForward returns the bind address and local port of the first -L
+// forward in an ssh command, understanding "-L [bind:]port:host:hostport"
+func extractLocalForward(command string) (string, string, error) {
+	args, err := parseSSHCommand(command)
+	if err != nil {
+		return "", "", err
+	}
+	parsed, _ := parseSSHArgs(args)
+	for _, forward := range parsed.LocalForwards {
+		if bind, port, ok := parseLocalForward(forward); ok {
+			return strings.Trim(bind, "[]"), port, nil
+		}
+	}
+	return "", "", fmt.Errorf("could not find local port in command")
+}
+
+// bindHost is the address ssh binds: the configured one, or "" for ssh's default of localhost
+func (t *Tunnel) bindHost() string {
+	return t.config.BindAddress
+}
+
+// listenAddress is the host:port ssh binds, as reported in the status
+func (t *Tunnel) listenAddress() string {
+	return net.JoinHostPort(displayHost(t.bindHost()), t.activePort())
+}
+
+// dialAddress is the address used to reach the forwarded port from this machine
+func (t *Tunnel) dialAddress() string {
+	return net.JoinHostPort(dialHost(t.bindHost()), t.activePort())
+}
+
+// forwardArgs rewrites the tunnel's -L forward so ssh binds host:port rather than
+// what the command says
+func (t *Tunnel) forwardArgs(args []string, host, port string) []string {
+	bind, commandPort, err := extractLocalForward(t.config.Command)
+	if err != nil {
+		return args
+	}
+	if bind == host && commandPort == port {
+		return args
+	}
+	return rewriteLocalForward(args, commandPort, host, port)
+}
+
+// rewriteLocalForward returns a copy of the ssh arguments in which every -L forward
+// on port from binds bind:to instead (just to when bind is empty)
+func rewriteLocalForward(args []string, from, bind, to string) []string {
+	local := to
+	if bind != "" {
+		if strings.Contains(bind, ":") {
+			bind = "[" + bind + "]"
+		}
+		local = bind + ":" + to
+	}
+
+	rewrite := func(spec string) string {
+		oldBind, port, ok := parseLocalForward(spec)
+		if !ok || port != from {
+			return spec
+		}
+		prefix := port
+		if oldBind != "" {
+			prefix = oldBind + ":" + port
+		}
+		return local + strings.TrimPrefix(spec, prefix)
+	}
+
+	rewritten := append([]string(nil), args...)
+	for i := 1; i < len(rewritten); i++ {
+		arg := rewritten[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+			break
+		}
+		for j := 1; j < len(arg); j++ {
+			if !strings.ContainsRune(sshFlagsWithValue, rune(arg[j])) {
+				continue
+			}
+			if j+1 < len(arg) {
+				if arg[j] == 'L' {
+					rewritten[i] = arg[:j+1] + rewrite(arg[j+1:])
+				}
+			} else if i+1 < len(rewritten) {
+				i++
+				if arg[j] == 'L' {
+					rewritten[i] = rewrite(rewritten[i])
+				}
+			}
+			break
+		}
+	}
+	return rewritten
+}
+
+// isBindAvailable checks whether port can be bound on every address host covers.
+// A loopback alias such as 127.0.0.2 using the same port does not make it unavailable.
+func isBindAvailable(host, port string) bool {
+	for _, address := range bindAddresses(host) {
+		// ssh skips ::1 when IPv6 is disabled, so its absence is no conflict
+		if address == "::1" && !canBindAddress(address) {
+			continue
+		}
+		ln, err := net.Listen("tcp", net.JoinHostPort(address, port))
+		if err != nil {
+			return false
+		}
+		ln.Close()
+	}
+	return true
+}
+
+// bindAddresses returns the IPs a bind address listens on: ssh's default of localhost
+// binds both loopback addresses and "*" binds every interface ("")
+func bindAddresses(host string) []string {
+	switch host {
+	case "", "localhost":
+		return []string{"127.0.0.1", "::1"}
+	case "*":
+		return []string{""}
+	}
+	return []string{host}
+}
+
+// canBindAddress reports whether the address exists on this machine
+func canBindAddress(host string) bool {
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return false
+	}
+	ln.Close()
+	return true
+}
+
+// listenerConflicts reports whether a listening socket occupies the port for host.
+// Wildcard listeners conflict with every address; otherwise only a socket on one of
+// the addresses host binds does.
+func listenerConflicts(socket PortProcess, host string) bool {
+	if host == "*" {
+		return true
+	}
+	socketHost, _, err := net.SplitHostPort(socket.LocalAddress)
+	if err != nil {
+		return true
+	}
+	ip := net.ParseIP(socketHost)
+	if ip == nil || ip.IsUnspecified() {
+		return true
+	}
+	for _, address := range bindAddresses(host) {
+		want := net.ParseIP(address)
+		if want == nil || want.IsUnspecified() || ip.Equal(want) {
+			return true
+		}
+	}
+	return false
+}
+
+// listenHost maps a bind address to something net.Listen accepts
+func listenHost(host string) string {
+	switch host {
+	case "", "localhost":
+		return "127.0.0.1"
+	case "*":
+		return ""
+	}
+	return host
+}
+
+// dialHost maps a bind address to the address a local client connects to
+func dialHost(host string) string {
+	switch host {
+	case "", "*", "0.0.0.0":
+		return "localhost"
+	case "::":
+		return "::1"
+	}
+	return host
+}
+
+// displayHost maps a bind address to how it is shown in the status
+func displayHost(host string) string {
+	switch host {
+	case "":
+		return "localhost"
+	case "*":
+		return "0.0.0.0"
+	}
+	return host
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+// listenOn occupies a free port on host for the rest of the test, skipping the test
+// when the address does not exist here (macOS without loopback aliases)
+func listenOn(t *testing.T, host string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", host, err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+}
+
+// freePort returns a port nothing listens on
+func freePort(t *testing.T) string {
+	t.Helper()
+	_, port, err := net.SplitHostPort(closedAddress(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+func TestListenerConflicts(t *testing.T) {
+	tests := []struct {
+		socket string
+		host   string
+		want   bool
+	}{
+		{"127.0.0.1:5432", "", true},
+		{"[::1]:5432", "", true},
+		{"0.0.0.0:5432", "", true},
+		{"[::]:5432", "", true},
+		{"127.0.0.2:5432", "", false},
+		{"192.168.1.10:5432", "", false},
+		{"127.0.0.2:5432", "localhost", false},
+		{"127.0.0.1:5432", "localhost", true},
+		{"127.0.0.2:5432", "127.0.0.2", true},
+		{"127.0.0.1:5432", "127.0.0.2", false},
+		{"0.0.0.0:5432", "127.0.0.2", true},
+		{"127.0.0.3:5432", "*", true},
+		{"127.0.0.3:5432", "0.0.0.0", true},
+		{"[::1]:5432", "::1", true},
+		{"127.0.0.1:5432", "::1", false},
+		{"garbage", "127.0.0.2", true},
+	}
+	for _, tt := range tests {
+		socket := PortProcess{State: "LISTEN", LocalAddress: tt.socket}
+		if got := listenerConflicts(socket, tt.host); got != tt.want {
+			t.Errorf("listenerConflicts(%s, %q) = %v, want %v", tt.socket, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestTunnelsShareLoopbackPort(t *testing.T) {
+	// A sibling tunnel on its own loopback address holds the port
+	port := listenOn(t, "127.0.0.2")
+
+	if !isBindAvailable("", port) || !isBindAvailable("localhost", port) {
+		t.Errorf("default bind on %s blocked by a listener on 127.0.0.2", port)
+	}
+	if !isBindAvailable("127.0.0.3", port) {
+		t.Errorf("127.0.0.3:%s blocked by a listener on 127.0.0.2", port)
+	}
+	if isBindAvailable("127.0.0.2", port) {
+		t.Errorf("127.0.0.2:%s reported free while in use", port)
+	}
+
+	// The sibling must never be taken for the owner of the default tunnel's port
+	if owners := listenersOnPort("", port); len(owners) != 0 {
+		t.Errorf("listeners for the default bind = %+v, want none", owners)
+	}
+
+	// Whereas a listener on 127.0.0.1 does occupy it
+	taken := listenOn(t, "127.0.0.1")
+	if isBindAvailable("", taken) {
+		t.Errorf("default bind on %s reported free while 127.0.0.1 holds it", taken)
+	}
+}
+
+func TestAllocateBinding(t *testing.T) {
+	if !canBindAddress("127.0.0.2") {
+		t.Skip("no loopback aliases on this machine")
+	}
+	port := freePort(t)
+
+	tests := []struct {
+		name     string
+		others   []TunnelConfig
+		previous TunnelConfig
+		config   TunnelConfig
+		check    func(TunnelConfig) bool
+	}{
+		{
+			name:     "loopback keeps its address and takes the edited port",
+			previous: TunnelConfig{Allocate: AllocateLoopback, BindAddress: "127.0.0.7", LocalPort: "8080"},
+			config:   TunnelConfig{Allocate: AllocateLoopback, LocalPort: port},
+			check:    func(c TunnelConfig) bool { return c.BindAddress == "127.0.0.7" && c.LocalPort == port },
+		},
+		{
+			name:     "loopback address taken by another tunnel",
+			others:   []TunnelConfig{{Name: "other", BindAddress: "127.0.0.7", LocalPort: port}},
+			previous: TunnelConfig{Allocate: AllocateLoopback, BindAddress: "127.0.0.7", LocalPort: port},
+			config:   TunnelConfig{Allocate: AllocateLoopback, LocalPort: port},
+			check:    func(c TunnelConfig) bool { return c.BindAddress != "127.0.0.7" && c.LocalPort == port },
+		},
+		{
+			name:     "port keeps its port and takes the edited address",
+			previous: TunnelConfig{Allocate: AllocatePort, LocalPort: port},
+			config:   TunnelConfig{Allocate: AllocatePort, BindAddress: "127.0.0.1", LocalPort: "1"},
+			check:    func(c TunnelConfig) bool { return c.BindAddress == "127.0.0.1" && c.LocalPort == port },
+		},
+		{
+			name:     "port taken by another tunnel",
+			others:   []TunnelConfig{{Name: "other", LocalPort: port}},
+			previous: TunnelConfig{Allocate: AllocatePort, LocalPort: port},
+			config:   TunnelConfig{Allocate: AllocatePort, LocalPort: port},
+			check:    func(c TunnelConfig) bool { return c.LocalPort != port && c.LocalPort != "" },
+		},
+		{
+			name:     "no allocation leaves both fields alone",
+			previous: TunnelConfig{BindAddress: "127.0.0.9", LocalPort: "9000"},
+			config:   TunnelConfig{BindAddress: "127.0.0.1", LocalPort: port},
+			check:    func(c TunnelConfig) bool { return c.BindAddress == "127.0.0.1" && c.LocalPort == port },
+		},
+	}
+	for _, tt := range tests {
+		tm := &TunnelManager{tunnels: map[string]*Tunnel{}}
+		for _, other := range tt.others {
+			tm.tunnels[other.Name] = &Tunnel{config: other}
+		}
+		tt.previous.Name, tt.config.Name = "web", "web"
+
+		config := tt.config
+		if err := tm.allocateBinding(&config, &tt.previous); err != nil {
+			t.Errorf("%s: error = %v", tt.name, err)
+			continue
+		}
+		if !tt.check(config) {
+			t.Errorf("%s: allocated %s:%s", tt.name, config.BindAddress, config.LocalPort)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// bulkCommands maps CLI commands to bulk actions
+var bulkCommands = map[string]string{
+	"up":      BulkStart,
+	"down":    BulkStop,
+	"restart": BulkRestart,
+}
+
+// runBulkCommand asks the running manager to apply a bulk action, e.g.
+// "easytunnel up -t db", and returns the process exit code
+func runBulkCommand(command string, args []string) int {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	var tags, status, server string
+	flags.StringVar(&tags, "t", "", "Select tunnels with any of these comma-separated tags")
+	flags.StringVar(&tags, "tag", "", "Same as -t")
+	flags.StringVar(&status, "s", "", "Select tunnels in any of these comma-separated states")
+	flags.StringVar(&status, "status", "", "Same as -s")
+	flags.StringVar(&server, "server", defaultServerURL(), "Address of the running manager")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [-t tag] [-s status] [name...]\n\n", os.Args[0], command)
+		flags.PrintDefaults()
+	}
+	names, err := parseInterspersed(flags, args)
+	if err != nil {
+		return 2
+	}
+
+	query := url.Values{}
+	if tags != "" {
+		query.Set("tag", tags)
+	}
+	if status != "" {
+		query.Set("status", status)
+	}
+	if len(names) > 0 {
+		query.Set("name", strings.Join(names, ","))
+	}
+	if len(query) == 0 {
+		fmt.Fprintf(os.Stderr, "Select tunnels with -t, -s or by name\n")
+		flags.Usage()
+		return 2
+	}
+
+	endpoint := strings.TrimSuffix(server, "/") + "/api/bulk/" + bulkCommands[command] + "?" + query.Encode()
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(endpoint, "application/json", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not reach the tunnel manager at %s: %v\n", server, err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "Error: %s\n", strings.TrimSpace(string(body)))
+		return 1
+	}
+
+	var result struct {
+		Tunnels []string `json:"tunnels"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid response: %v\n", err)
+		return 1
+	}
+
+	if len(result.Tunnels) == 0 {
+		fmt.Printf("No tunnels to %s\n", bulkCommands[command])
+		return 0
+	}
+	for _, name := range result.Tunnels {
+		fmt.Printf("%s: %s\n", bulkCommands[command], name)
+	}
+	return 0
+}
+
+// parseInterspersed parses flags that may come before, between or after the
+// positional arguments, as in "easytunnel up web --tag db", and returns the
+// positional ones. Everything after "--" is positional.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// defaultServerURL returns the address the manager listens on, honouring PORT
+func defaultServerURL() string {
+	port := "10000"
+	if envPort := os.Getenv("PORT"); envPort != "" {
+		port = envPort
+	}
+	return "http://localhost:" + port
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestParseInterspersed(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		names   []string
+		tags    string
+		status  string
+		wantErr bool
+	}{
+		{"flags first", []string{"-t", "db", "web"}, []string{"web"}, "db", "", false},
+		{"flags after names", []string{"web", "--tag", "db"}, []string{"web"}, "db", "", false},
+		{"flags between names", []string{"web", "-s=error", "api", "-t", "db,cache", "worker"}, []string{"web", "api", "worker"}, "db,cache", "error", false},
+		{"names only", []string{"web", "api"}, []string{"web", "api"}, "", "", false},
+		{"flags only", []string{"--status", "connected"}, nil, "", "connected", false},
+		{"double dash", []string{"-t", "db", "--", "web", "-odd"}, []string{"web", "-odd"}, "db", "", false},
+		{"nothing", nil, nil, "", "", false},
+		{"unknown flag after a name", []string{"web", "--force"}, nil, "", "", true},
+		{"missing value after a name", []string{"web", "-t"}, nil, "", "", true},
+	}
+	for _, tt := range tests {
+		flags := flag.NewFlagSet("up", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		var tags, status string
+		flags.StringVar(&tags, "t", "", "")
+		flags.StringVar(&tags, "tag", "", "")
+		flags.StringVar(&status, "s", "", "")
+		flags.StringVar(&status, "status", "", "")
+
+		names, err := parseInterspersed(flags, tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if !reflect.DeepEqual(names, tt.names) || tags != tt.tags || status != tt.status {
+			t.Errorf("%s: names %q, tags %q, status %q; want %q, %q, %q", tt.name, names, tags, status, tt.names, tt.tags, tt.status)
+		}
+	}
+}
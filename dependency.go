@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dependencyPollInterval is how often a waiting tunnel rechecks its dependencies;
+// it is also woken as soon as a dependency connects
+const dependencyPollInterval = 5 * time.Second
+
+// validateDependencies checks that every dependency exists and that adding config
+// does not create a cycle. The caller must hold tm.mutex.
+func (tm *TunnelManager) validateDependencies(config TunnelConfig) error {
+	for _, name := range config.DependsOn {
+		if name == config.Name {
+			return fmt.Errorf("tunnel cannot depend on itself")
+		}
+		if _, exists := tm.tunnels[name]; !exists {
+			return fmt.Errorf("unknown dependency %q", name)
+		}
+	}
+
+	graph := make(map[string][]string, len(tm.tunnels)+1)
+	for name, tunnel := range tm.tunnels {
+		graph[name] = tunnel.config.DependsOn
+	}
+	graph[config.Name] = config.DependsOn
+
+	if cycle := findDependencyCycle(graph); cycle != nil {
+		return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// findDependencyCycle returns a cycle in the graph, such as [a b a], or nil
+func findDependencyCycle(graph map[string][]string) []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(graph))
+	var path []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			for i, n := range path {
+				if n == name {
+					return append(append([]string(nil), path[i:]...), name)
+				}
+			}
+		case done:
+			return nil
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range graph[name] {
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		return nil
+	}
+
+	names := make([]string, 0, len(graph))
+	for name := range graph {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// dependencyOrder sorts tunnel names so every tunnel comes after its dependencies.
+// Names caught in a cycle, which only a hand-edited config can contain, come last.
+func dependencyOrder(graph map[string][]string) []string {
+	names := make([]string, 0, len(graph))
+	for name := range graph {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	placed := make(map[string]bool, len(graph))
+	var order []string
+	for len(order) < len(names) {
+		progress := false
+		for _, name := range names {
+			if placed[name] {
+				continue
+			}
+			ready := true
+			for _, dep := range graph[name] {
+				if _, known := graph[dep]; known && !placed[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				placed[name] = true
+				order = append(order, name)
+				progress = true
+			}
+		}
+		if !progress {
+			for _, name := range names {
+				if !placed[name] {
+					order = append(order, name)
+				}
+			}
+			break
+		}
+	}
+	return order
+}
+
+// startOrder returns the tunnels with dependencies before dependents, for a stable
+// order when acting on many tunnels. Tunnels started from it still start concurrently.
+// The caller must hold tm.mutex.
+func (tm *TunnelManager) startOrder() []*Tunnel {
+	graph := make(map[string][]string, len(tm.tunnels))
+	for name, tunnel := range tm.tunnels {
+		graph[name] = tunnel.config.DependsOn
+	}
+
+	tunnels := make([]*Tunnel, 0, len(graph))
+	for _, name := range dependencyOrder(graph) {
+		tunnels = append(tunnels, tm.tunnels[name])
+	}
+	return tunnels
+}
+
+// dependents returns the tunnels that list name in dependsOn. The caller must hold tm.mutex.
+func (tm *TunnelManager) dependents(name string) []*Tunnel {
+	var dependents []*Tunnel
+	for _, tunnel := range tm.tunnels {
+		for _, dep := range tunnel.config.DependsOn {
+			if dep == name {
+				dependents = append(dependents, tunnel)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
+// dependentNames returns the sorted names of the tunnels that depend on name. The
+// caller must hold tm.mutex.
+func (tm *TunnelManager) dependentNames(name string) []string {
+	var names []string
+	for _, dependent := range tm.dependents(name) {
+		names = append(names, dependent.config.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// unmetDependency describes the first dependency that is not connected, or returns
+// "" when all are. The caller must not hold t.mutex or tm.mutex.
+func (t *Tunnel) unmetDependency() string {
+	if len(t.config.DependsOn) == 0 || t.manager == nil {
+		return ""
+	}
+
+	tm := t.manager
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	for _, name := range t.config.DependsOn {
+		dep, exists := tm.tunnels[name]
+		if !exists {
+			return name + " (deleted)"
+		}
+
+		dep.mutex.RLock()
+		status, enabled := dep.status, dep.config.Enabled
+		dep.mutex.RUnlock()
+
+		switch {
+		case !enabled:
+			return name + " (disabled)"
+		case status == StateIdle:
+			// An on-demand dependency is started by its dependents too
+			dep.Activate()
+			return fmt.Sprintf("%s (%s)", name, status)
+		case status != StateConnected:
+			return fmt.Sprintf("%s (%s)", name, status)
+		}
+	}
+	return ""
+}
+
+// waitForDependencies holds the tunnel in the waiting state until every dependency is
+// connected. It returns false if the tunnel was stopped meanwhile.
+func (t *Tunnel) waitForDependencies(ctx context.Context) bool {
+	unmet := t.unmetDependency()
+	if unmet == "" {
+		return true
+	}
+	log.Printf("Tunnel '%s' waiting for dependency %s", t.config.Name, unmet)
+
+	ticker := time.NewTicker(dependencyPollInterval)
+	defer ticker.Stop()
+
+	for unmet != "" {
+		t.mutex.Lock()
+		if ctx.Err() == nil {
+			t.setState(StateWaiting, "Waiting for dependency: "+unmet)
+		}
+		t.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		case <-t.wake:
+		}
+		unmet = t.unmetDependency()
+	}
+
+	log.Printf("Dependencies of tunnel '%s' are connected", t.config.Name)
+	return true
+}
+
+// cascades reports whether a transition matters to the tunnel's dependents: it
+// connected, or it stopped being up
+func cascades(transition TunnelTransition) bool {
+	wasUp := transition.From == StateConnected || transition.From == StateUnhealthy
+	isUp := transition.To == StateConnected || transition.To == StateUnhealthy
+	return transition.From != transition.To && (wasUp != isUp || transition.To == StateConnected)
+}
+
+// cascadeDependencies reacts to a tunnel's transition on behalf of its dependents:
+// they are woken when it connects and restarted, to wait for it again, when it drops.
+// setState calls it for every transition rather than leaving it to the event queue,
+// which drops events in a burst.
+func (tm *TunnelManager) cascadeDependencies(transition TunnelTransition) {
+	wasUp := transition.From == StateConnected || transition.From == StateUnhealthy
+	isUp := transition.To == StateConnected || transition.To == StateUnhealthy
+
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	for _, dependent := range tm.dependents(transition.Tunnel) {
+		switch {
+		case transition.To == StateConnected:
+			dependent.Wake("dependency " + transition.Tunnel + " connected")
+		case wasUp && !isUp:
+			go dependent.Restart(fmt.Sprintf("dependency %s %s", transition.Tunnel, transition.To))
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DNS defaults
+const (
+	defaultDNSListen = "127.0.0.1:10053"
+	defaultDNSDomain = "tunnel"
+	defaultDNSTTL    = 5
+	defaultHostsFile = "/etc/hosts"
+)
+
+// Markers around the block of tunnel names in the hosts file
+const (
+	hostsBlockBegin = "# BEGIN easytunnel - managed automatically, do not edit"
+	hostsBlockEnd   = "# END easytunnel"
+)
+
+// DNS message constants from RFC 1035 and RFC 3596
+const (
+	dnsTypeA     = 1
+	dnsTypeAAAA  = 28
+	dnsTypeANY   = 255
+	dnsClassIN   = 1
+	dnsRcodeOK   = 0
+	dnsRcodeFail = 2
+	dnsRcodeNX   = 3
+	dnsRcodeImpl = 4
+	dnsRcodeRef  = 5
+)
+
+// DNSSettings configures local names such as prod-db.tunnel for tunnels
+type DNSSettings struct {
+	Enabled     bool   `json:"enabled"`             // Run the embedded DNS responder
+	Listen      string `json:"listen,omitempty"`    // UDP address of the responder
+	Domain      string `json:"domain,omitempty"`    // Suffix for tunnel names
+	TTL         uint32 `json:"ttl,omitempty"`       // Seconds resolvers may cache answers
+	ManageHosts bool   `json:"manageHosts"`         // Keep a block of tunnel names in the hosts file
+	HostsFile   string `json:"hostsFile,omitempty"` // Hosts file to manage
+}
+
+// validate checks the DNS settings
+func (s DNSSettings) validate() error {
+	if s.Listen != "" {
+		if _, err := net.ResolveUDPAddr("udp", s.Listen); err != nil {
+			return fmt.Errorf("invalid listen address %q: %v", s.Listen, err)
+		}
+	}
+	if s.Domain != "" {
+		for _, label := range strings.Split(s.domain(), ".") {
+			if label == "" || dnsLabel(label) != label {
+				return fmt.Errorf("invalid domain %q", s.Domain)
+			}
+		}
+	}
+	return nil
+}
+
+// listen returns the responder address, falling back to the default
+func (s DNSSettings) listen() string {
+	if s.Listen == "" {
+		return defaultDNSListen
+	}
+	return s.Listen
+}
+
+// domain returns the name suffix without dots, falling back to the default
+func (s DNSSettings) domain() string {
+	domain := strings.ToLower(strings.Trim(s.Domain, "."))
+	if domain == "" {
+		return defaultDNSDomain
+	}
+	return domain
+}
+
+// ttl returns the answer TTL, falling back to the default
+func (s DNSSettings) ttl() uint32 {
+	if s.TTL == 0 {
+		return defaultDNSTTL
+	}
+	return s.TTL
+}
+
+// hostsFile returns the hosts file path, falling back to the default
+func (s DNSSettings) hostsFile() string {
+	if s.HostsFile == "" {
+		return defaultHostsFile
+	}
+	return s.HostsFile
+}
+
+// dnsLabel turns a tunnel name into a DNS label: lower case, with anything other than
+// letters, digits and hyphens replaced by hyphens
+func dnsLabel(name string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' {
+			b.WriteRune(c)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	label := strings.Trim(b.String(), "-")
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	return label
+}
+
+// bindIP is the IP clients use to reach a tunnel bound to host
+func bindIP(host string) net.IP {
+	switch host {
+	case "", "localhost", "*", "0.0.0.0":
+		return net.IPv4(127, 0, 0, 1)
+	case "::":
+		return net.IPv6loopback
+	}
+	return net.ParseIP(host)
+}
+
+// validateHostname rejects a tunnel whose name turns into the same DNS label as
+// another tunnel's, e.g. prod_db and prod-db. The caller must hold tm.mutex.
+func (tm *TunnelManager) validateHostname(config TunnelConfig) error {
+	label := dnsLabel(config.Name)
+	if label == "" {
+		return nil
+	}
+	for name := range tm.tunnels {
+		if name != config.Name && dnsLabel(name) == label {
+			return fmt.Errorf("tunnel name %q gives the same hostname as tunnel %q", config.Name, name)
+		}
+	}
+	return nil
+}
+
+// tunnelNames maps the fully qualified name of every tunnel to its bind IP.
+// The caller must hold tm.mutex.
+func (tm *TunnelManager) tunnelNames() map[string]net.IP {
+	// Colliding names saved before they were rejected resolve to the first tunnel by
+	// name, so the answer does not change between refreshes
+	sorted := make([]string, 0, len(tm.tunnels))
+	for name := range tm.tunnels {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	domain := tm.settings.DNS.domain()
+	names := make(map[string]net.IP)
+	for _, name := range sorted {
+		tunnel := tm.tunnels[name]
+		label := dnsLabel(tunnel.config.Name)
+		ip := bindIP(tunnel.config.BindAddress)
+		if label == "" || ip == nil {
+			continue
+		}
+		if _, taken := names[label+"."+domain]; !taken {
+			names[label+"."+domain] = ip
+		}
+	}
+	return names
+}
+
+// tunnelHostname returns the tunnel's local name, or "" when names are not served
+func (tm *TunnelManager) tunnelHostname(tunnel *Tunnel) string {
+	dns := tm.settings.DNS
+	label := dnsLabel(tunnel.config.Name)
+	if (!dns.Enabled && !dns.ManageHosts) || label == "" {
+		return ""
+	}
+	return label + "." + dns.domain()
+}
+
+// lookupTunnelName resolves a name to a tunnel's bind IP
+func (tm *TunnelManager) lookupTunnelName(name string) (net.IP, bool) {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	ip, ok := tm.tunnelNames()[strings.ToLower(strings.TrimSuffix(name, "."))]
+	return ip, ok
+}
+
+// startDNS runs the embedded DNS responder until ctx is cancelled
+func (tm *TunnelManager) startDNS(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", tm.settings.DNS.listen())
+	if err != nil {
+		return fmt.Errorf("DNS listen on %s: %v", tm.settings.DNS.listen(), err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("DNS responder stopped: %v", err)
+				}
+				return
+			}
+			if response := tm.answerDNS(buf[:n]); response != nil {
+				conn.WriteTo(response, addr)
+			}
+		}
+	}()
+
+	log.Printf("🔎 DNS responder for *.%s listening on %s", tm.settings.DNS.domain(), tm.settings.DNS.listen())
+	return nil
+}
+
+// answerDNS builds the response to a single-question query. Names under the tunnel
+// domain are answered authoritatively; anything else is refused.
+func (tm *TunnelManager) answerDNS(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	flags := binary.BigEndian.Uint16(query[2:4])
+	if flags&0x8000 != 0 {
+		return nil // a response, not a query
+	}
+
+	response := make([]byte, 12, 512)
+	copy(response[0:2], query[0:2])
+	opcode := flags & 0x7800
+	respond := func(rcode uint16, question []byte, answers [][]byte) []byte {
+		// QR, AA, the query's opcode and RD, plus the response code
+		binary.BigEndian.PutUint16(response[2:4], 0x8000|opcode|0x0400|flags&0x0100|rcode)
+		if question != nil {
+			binary.BigEndian.PutUint16(response[4:6], 1)
+			response = append(response, question...)
+		}
+		binary.BigEndian.PutUint16(response[6:8], uint16(len(answers)))
+		for _, answer := range answers {
+			response = append(response, answer...)
+		}
+		return response
+	}
+
+	if opcode != 0 {
+		return respond(dnsRcodeImpl, nil, nil)
+	}
+	if binary.BigEndian.Uint16(query[4:6]) != 1 {
+		return respond(dnsRcodeFail, nil, nil)
+	}
+
+	name, end, ok := parseDNSName(query, 12)
+	if !ok || end+4 > len(query) {
+		return respond(dnsRcodeFail, nil, nil)
+	}
+	question := query[12 : end+4]
+	qtype := binary.BigEndian.Uint16(query[end : end+2])
+	qclass := binary.BigEndian.Uint16(query[end+2 : end+4])
+
+	domain := tm.settings.DNS.domain()
+	if name != domain && !strings.HasSuffix(name, "."+domain) {
+		return respond(dnsRcodeRef, question, nil)
+	}
+
+	ip, found := tm.lookupTunnelName(name)
+	if !found {
+		return respond(dnsRcodeNX, question, nil)
+	}
+
+	var answers [][]byte
+	if qclass == dnsClassIN {
+		ttl := tm.settings.DNS.ttl()
+		if ip4 := ip.To4(); ip4 != nil && (qtype == dnsTypeA || qtype == dnsTypeANY) {
+			answers = append(answers, dnsRecord(dnsTypeA, ttl, ip4))
+		} else if ip4 == nil && (qtype == dnsTypeAAAA || qtype == dnsTypeANY) {
+			answers = append(answers, dnsRecord(dnsTypeAAAA, ttl, ip.To16()))
+		}
+	}
+	// No matching record type is an empty NOERROR answer, not NXDOMAIN
+	return respond(dnsRcodeOK, question, answers)
+}
+
+// parseDNSName reads an uncompressed name starting at offset and returns it in lower
+// case with the offset just past it
+func parseDNSName(msg []byte, offset int) (string, int, bool) {
+	var labels []string
+	for {
+		if offset >= len(msg) {
+			return "", 0, false
+		}
+		length := int(msg[offset])
+		offset++
+		if length == 0 {
+			break
+		}
+		if length&0xC0 != 0 || offset+length > len(msg) {
+			return "", 0, false // questions are never compressed in practice
+		}
+		labels = append(labels, strings.ToLower(string(msg[offset:offset+length])))
+		offset += length
+	}
+	return strings.Join(labels, "."), offset, true
+}
+
+// dnsRecord encodes an answer for the question name, referenced by pointer
+func dnsRecord(rtype uint16, ttl uint32, data []byte) []byte {
+	record := make([]byte, 12, 12+len(data))
+	binary.BigEndian.PutUint16(record[0:2], 0xC00C) // pointer to the name in the question
+	binary.BigEndian.PutUint16(record[2:4], rtype)
+	binary.BigEndian.PutUint16(record[4:6], dnsClassIN)
+	binary.BigEndian.PutUint32(record[6:10], ttl)
+	binary.BigEndian.PutUint16(record[10:12], uint16(len(data)))
+	return append(record, data...)
+}
+
+// syncHostsFile rewrites the managed block of the hosts file to match the current
+// tunnels. The caller must hold tm.mutex.
+func (tm *TunnelManager) syncHostsFile() {
+	if !tm.settings.DNS.ManageHosts {
+		return
+	}
+
+	names := tm.tunnelNames()
+	hostnames := make([]string, 0, len(names))
+	for name := range names {
+		hostnames = append(hostnames, name)
+	}
+	sort.Strings(hostnames)
+
+	var block []string
+	for _, name := range hostnames {
+		block = append(block, fmt.Sprintf("%s\t%s", names[name], name))
+	}
+	tm.writeHostsBlock(block)
+}
+
+// clearHostsFile removes the managed block from the hosts file
+func (tm *TunnelManager) clearHostsFile() {
+	if tm.settings.DNS.ManageHosts {
+		tm.writeHostsBlock(nil)
+	}
+}
+
+// writeHostsBlock replaces the managed block with the given lines, leaving the rest
+// of the file untouched. The file is only written when something changed.
+func (tm *TunnelManager) writeHostsBlock(lines []string) {
+	path := tm.settings.DNS.hostsFile()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error reading hosts file %s: %v", path, err)
+		return
+	}
+
+	var kept []string
+	inBlock := false
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		switch {
+		case line == hostsBlockBegin:
+			inBlock = true
+		case line == hostsBlockEnd:
+			inBlock = false
+		case !inBlock:
+			kept = append(kept, line)
+		}
+	}
+	for len(kept) > 0 && kept[len(kept)-1] == "" {
+		kept = kept[:len(kept)-1]
+	}
+
+	if len(lines) > 0 {
+		kept = append(kept, "", hostsBlockBegin)
+		kept = append(kept, lines...)
+		kept = append(kept, hostsBlockEnd)
+	}
+	updated := []byte(strings.Join(kept, "\n") + "\n")
+
+	if bytes.Equal(updated, data) {
+		return
+	}
+	if err := replaceFile(path, updated); err != nil {
+		log.Printf("Error updating hosts file %s: %v", path, err)
+		return
+	}
+	log.Printf("Updated %d tunnel name(s) in %s", len(lines), path)
+}
+
+// replaceFile writes data to a temporary file beside path and renames it into place,
+// so a crash or a full disk never leaves the hosts file truncated. The mode and owner
+// of the existing file are kept, and a symlinked file is replaced at its target.
+func replaceFile(path string, data []byte) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if info != nil {
+		mode = info.Mode().Perm()
+		if err := copyOwner(tmp.Name(), info); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
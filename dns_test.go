@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+)
+
+// dnsQuery encodes a standard single-question query
+func dnsQuery(id uint16, name string, qtype uint16) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	binary.BigEndian.PutUint16(msg[2:4], 0x0100) // RD
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(msg[len(msg)-4:], qtype)
+	binary.BigEndian.PutUint16(msg[len(msg)-2:], dnsClassIN)
+	return msg
+}
+
+// dnsTestManager serves prod-db.tunnel on 127.0.0.2 and web.tunnel on ::1
+func dnsTestManager() *TunnelManager {
+	return &TunnelManager{tunnels: map[string]*Tunnel{
+		"prod_db": {config: TunnelConfig{Name: "prod_db", BindAddress: "127.0.0.2"}},
+		"web":     {config: TunnelConfig{Name: "web", BindAddress: "::1"}},
+	}}
+}
+
+func TestParseDNSName(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    []byte
+		offset int
+		want   string
+		end    int
+		ok     bool
+	}{
+		{"simple", []byte("\x07prod-db\x06tunnel\x00"), 0, "prod-db.tunnel", 16, true},
+		{"lower-cased", []byte("\x03WeB\x06Tunnel\x00\x00\x01"), 0, "web.tunnel", 12, true},
+		{"root", []byte("\x00"), 0, "", 1, true},
+		{"at offset", []byte("xx\x01a\x00"), 2, "a", 5, true},
+		{"empty message", nil, 0, "", 0, false},
+		{"offset past end", []byte("\x00"), 4, "", 0, false},
+		{"label past end", []byte("\x07prod"), 0, "", 0, false},
+		{"no terminator", []byte("\x03web"), 0, "", 0, false},
+		{"compression pointer", []byte("\xc0\x0c"), 0, "", 0, false},
+		{"pointer after label", []byte("\x03web\xc0\x00"), 0, "", 0, false},
+		{"reserved label type", []byte("\x40abc\x00"), 0, "", 0, false},
+		{"maximum length byte", []byte("\xff"), 0, "", 0, false},
+	}
+	for _, tt := range tests {
+		name, end, ok := parseDNSName(tt.msg, tt.offset)
+		if ok != tt.ok || (ok && (name != tt.want || end != tt.end)) {
+			t.Errorf("%s: parseDNSName() = %q, %d, %v, want %q, %d, %v", tt.name, name, end, ok, tt.want, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestAnswerDNS(t *testing.T) {
+	tm := dnsTestManager()
+
+	notQuery := dnsQuery(1, "prod-db.tunnel", dnsTypeA)
+	notQuery[2] |= 0x80
+	update := dnsQuery(2, "prod-db.tunnel", dnsTypeA)
+	update[2] |= 0x28 // opcode 5
+	twoQuestions := dnsQuery(3, "prod-db.tunnel", dnsTypeA)
+	twoQuestions[5] = 2
+
+	tests := []struct {
+		name    string
+		query   []byte
+		rcode   uint16
+		answers int
+		data    net.IP
+	}{
+		{"A record", dnsQuery(10, "prod-db.tunnel", dnsTypeA), dnsRcodeOK, 1, net.ParseIP("127.0.0.2").To4()},
+		{"case insensitive", dnsQuery(11, "PROD-DB.Tunnel", dnsTypeA), dnsRcodeOK, 1, net.ParseIP("127.0.0.2").To4()},
+		{"AAAA record", dnsQuery(12, "web.tunnel", dnsTypeAAAA), dnsRcodeOK, 1, net.IPv6loopback},
+		{"ANY", dnsQuery(13, "prod-db.tunnel", dnsTypeANY), dnsRcodeOK, 1, net.ParseIP("127.0.0.2").To4()},
+		{"no record of that type", dnsQuery(14, "prod-db.tunnel", dnsTypeAAAA), dnsRcodeOK, 0, nil},
+		{"unknown tunnel", dnsQuery(15, "missing.tunnel", dnsTypeA), dnsRcodeNX, 0, nil},
+		{"outside the domain", dnsQuery(16, "example.com", dnsTypeA), dnsRcodeRef, 0, nil},
+		{"other opcode", update, dnsRcodeImpl, 0, nil},
+		{"two questions", twoQuestions, dnsRcodeFail, 0, nil},
+	}
+	for _, tt := range tests {
+		response := tm.answerDNS(tt.query)
+		if len(response) < 12 {
+			t.Errorf("%s: short response %x", tt.name, response)
+			continue
+		}
+		flags := binary.BigEndian.Uint16(response[2:4])
+		if response[0] != tt.query[0] || response[1] != tt.query[1] || flags&0x8000 == 0 {
+			t.Errorf("%s: response header %x does not answer query %x", tt.name, response[:4], tt.query[:4])
+		}
+		if rcode := flags & 0x000F; rcode != tt.rcode {
+			t.Errorf("%s: rcode = %d, want %d", tt.name, rcode, tt.rcode)
+		}
+		if answers := int(binary.BigEndian.Uint16(response[6:8])); answers != tt.answers {
+			t.Errorf("%s: %d answers, want %d", tt.name, answers, tt.answers)
+		}
+		if tt.data != nil && !strings.HasSuffix(string(response), string(tt.data)) {
+			t.Errorf("%s: response %x does not end with %x", tt.name, response, []byte(tt.data))
+		}
+	}
+
+	if response := tm.answerDNS(notQuery); response != nil {
+		t.Errorf("answered a response: %x", response)
+	}
+}
+
+// answerSafely runs answerDNS and reports a panic as a test failure
+func answerSafely(t *testing.T, tm *TunnelManager, name string, query []byte) (response []byte) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("%s: answerDNS(%x) panicked: %v", name, query, r)
+		}
+	}()
+	return tm.answerDNS(query)
+}
+
+func TestAnswerDNSMalformed(t *testing.T) {
+	tm := dnsTestManager()
+	valid := dnsQuery(7, "prod-db.tunnel", dnsTypeA)
+
+	// Every truncation of a valid query
+	for n := 0; n < len(valid); n++ {
+		response := answerSafely(t, tm, "truncated", valid[:n])
+		if n < 12 && response != nil {
+			t.Errorf("truncated to %d bytes: answered a header-less packet", n)
+		}
+		if n >= 12 && response != nil && binary.BigEndian.Uint16(response[2:4])&0x000F == dnsRcodeOK {
+			t.Errorf("truncated to %d bytes: answered NOERROR", n)
+		}
+	}
+
+	// Questions using compression pointers, including pointers to themselves
+	header := valid[:12]
+	for _, name := range []string{"\xc0\x0c", "\xc0\x00", "\x03web\xc0\x0c", "\xc0", "\xff\xff"} {
+		query := append(append(append([]byte(nil), header...), name...), 0, 1, 0, 1)
+		response := answerSafely(t, tm, "compressed", query)
+		if response == nil || binary.BigEndian.Uint16(response[2:4])&0x000F != dnsRcodeFail {
+			t.Errorf("compressed name %x: response %x, want SERVFAIL", name, response)
+		}
+	}
+
+	// Random garbage, with and without a plausible header
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		packet := make([]byte, random.Intn(64))
+		random.Read(packet)
+		if i%2 == 0 && len(packet) >= 12 {
+			copy(packet, header)
+		}
+		answerSafely(t, tm, "garbage", packet)
+	}
+}
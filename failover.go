@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Endpoint selection for tunnels with alternative bastions
+const (
+	SelectPriority   = "priority"    // The command's own host first, then the alternatives in order
+	SelectRoundRobin = "round-robin" // Every connection attempt moves on to the next endpoint
+)
+
+// failbackCheckInterval is how often a failed-over tunnel checks whether its primary
+// endpoint is back
+const failbackCheckInterval = 30 * time.Second
+
+// validateEndpoints checks the alternative endpoints and how one is selected
+func validateEndpoints(config TunnelConfig) error {
+	switch config.EndpointSelection {
+	case "", SelectPriority, SelectRoundRobin:
+	default:
+		return fmt.Errorf("unknown endpointSelection %q", config.EndpointSelection)
+	}
+	if len(config.Endpoints) == 0 {
+		if config.EndpointSelection != "" {
+			return fmt.Errorf("endpointSelection requires endpoints")
+		}
+		return nil
+	}
+
+	for _, endpoint := range config.Endpoints {
+		if endpoint == "" || strings.HasPrefix(endpoint, "-") || strings.ContainsAny(endpoint, " \t") {
+			return fmt.Errorf("invalid endpoint %q", endpoint)
+		}
+	}
+	args, err := parseSSHCommand(config.Command)
+	if err != nil {
+		return err
+	}
+	if _, err := destinationIndex(args); err != nil {
+		return fmt.Errorf("cannot use alternative endpoints: %v", err)
+	}
+	return nil
+}
+
+// destinationIndex returns the position of the destination host in ssh's arguments
+func destinationIndex(args []string) (int, error) {
+	parsed, err := parseSSHArgs(args)
+	if err != nil {
+		return 0, err
+	}
+	i := 1 + len(parsed.OptionArgs)
+	if args[i] == "--" {
+		i++
+	}
+	return i, nil
+}
+
+// withDestination returns a copy of the ssh arguments connecting to destination instead
+func withDestination(args []string, destination string) ([]string, error) {
+	i, err := destinationIndex(args)
+	if err != nil {
+		return nil, err
+	}
+	replaced := append([]string(nil), args...)
+	replaced[i] = destination
+	return replaced, nil
+}
+
+// endpointCount returns the number of endpoints: the command's host and the
+// alternatives. The caller must hold t.mutex, as for the other endpoint helpers,
+// since updateTunnel replaces the configuration.
+func (t *Tunnel) endpointCount() int {
+	return 1 + len(t.config.Endpoints)
+}
+
+// roundRobin reports whether connection attempts rotate over the endpoints
+func (t *Tunnel) roundRobin() bool {
+	return t.config.EndpointSelection == SelectRoundRobin && len(t.config.Endpoints) > 0
+}
+
+// endpointName returns the destination of endpoint i as written in the configuration
+func (t *Tunnel) endpointName(i int) string {
+	if i > 0 && i <= len(t.config.Endpoints) {
+		return t.config.Endpoints[i-1]
+	}
+	if args, err := parseSSHCommand(t.config.Command); err == nil {
+		if parsed, err := parseSSHArgs(args); err == nil {
+			return parsed.Destination
+		}
+	}
+	return "primary"
+}
+
+// endpointArgs returns the ssh arguments connecting to endpoint i. The caller must
+// hold t.mutex.
+func (t *Tunnel) endpointArgs(i int) ([]string, error) {
+	args, err := parseSSHCommand(t.config.Command)
+	if err != nil || i <= 0 || i > len(t.config.Endpoints) {
+		return args, err
+	}
+	return withDestination(args, t.config.Endpoints[i-1])
+}
+
+// activeArgs returns the ssh arguments for the active endpoint. The caller must not
+// hold t.mutex.
+func (t *Tunnel) activeArgs() ([]string, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.endpointArgs(t.endpointIndex)
+}
+
+// switchEndpoint makes endpoint i the active one and records why. The caller must
+// hold t.mutex.
+func (t *Tunnel) switchEndpoint(i int, action, reason string) {
+	from, to := t.endpointName(t.endpointIndex), t.endpointName(i)
+	t.endpointIndex = i
+	t.endpoint = nil
+
+	message := fmt.Sprintf("%s -> %s: %s", from, to, reason)
+	t.recordEvent(action, message, nil)
+	log.Printf("Tunnel '%s' %s %s", t.config.Name, action, message)
+}
+
+// nextEndpoint moves a round-robin tunnel on to the next endpoint before a connection
+// attempt; the first attempt after a start keeps the current one
+func (t *Tunnel) nextEndpoint() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.roundRobin() {
+		return
+	}
+	if t.endpointUsed {
+		t.endpointIndex = (t.endpointIndex + 1) % t.endpointCount()
+		t.endpoint = nil
+		log.Printf("Tunnel '%s' trying endpoint %s", t.config.Name, t.endpointName(t.endpointIndex))
+	}
+	t.endpointUsed = true
+}
+
+// failover switches to the first reachable alternative after the active endpoint was
+// found unreachable, in priority order or, for round-robin, in rotation order. It
+// returns false when no other endpoint can be reached either.
+func (t *Tunnel) failover(ctx context.Context) bool {
+	t.mutex.RLock()
+	active, count := t.endpointIndex, t.endpointCount()
+	roundRobin := t.roundRobin()
+	t.mutex.RUnlock()
+
+	for step := 1; step < count; step++ {
+		i := step - 1
+		if i >= active {
+			i = step // Priority order, skipping the active endpoint
+		}
+		if roundRobin {
+			i = (active + step) % count
+		}
+
+		t.mutex.RLock()
+		args, err := t.endpointArgs(i)
+		t.mutex.RUnlock()
+		if err != nil {
+			continue
+		}
+		endpoint, err := resolveEndpointArgs(args)
+		if err != nil || !t.canReach(endpoint) {
+			continue
+		}
+
+		t.mutex.Lock()
+		if ctx.Err() != nil {
+			t.mutex.Unlock()
+			return false
+		}
+		t.switchEndpoint(i, "failover", "endpoint unreachable")
+		t.endpoint = &endpoint
+		t.mutex.Unlock()
+		return true
+	}
+	return false
+}
+
+// failoverExhausted moves on to the next endpoint, with a fresh retry budget, when the
+// active one has used up its attempts. It returns false once every endpoint has. The
+// caller must hold t.mutex.
+func (t *Tunnel) failoverExhausted(retry *backoff) bool {
+	// Round-robin attempts already share one budget across all endpoints
+	if len(t.config.Endpoints) == 0 || t.roundRobin() || t.exhaustedEndpoints+1 >= t.endpointCount() {
+		return false
+	}
+
+	t.exhaustedEndpoints++
+	next := (t.endpointIndex + 1) % t.endpointCount()
+	t.switchEndpoint(next, "failover", fmt.Sprintf("gave up after %d attempts: %s", retry.attempt, t.lastError))
+
+	retry.reset()
+	t.attempt = 0
+	t.nextRetryAt = time.Time{}
+	return true
+}
+
+// watchFailback returns a connected tunnel to its primary endpoint once that can be
+// reached again. Round-robin tunnels have no primary to return to.
+func (t *Tunnel) watchFailback(ctx context.Context) {
+	ticker := time.NewTicker(failbackCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		t.mutex.RLock()
+		failedOver := t.endpointIndex > 0 && !t.roundRobin()
+		up := t.status == StateConnected || t.status == StateUnhealthy
+		args, err := t.endpointArgs(0)
+		t.mutex.RUnlock()
+		if !failedOver || !up || err != nil {
+			continue
+		}
+		endpoint, err := resolveEndpointArgs(args)
+		if err != nil || !t.canReach(endpoint) {
+			continue
+		}
+
+		t.mutex.Lock()
+		if ctx.Err() != nil || t.endpointIndex == 0 {
+			t.mutex.Unlock()
+			continue
+		}
+		primary := t.endpointName(0)
+		t.switchEndpoint(0, "failback", "primary endpoint reachable again")
+		t.exhaustedEndpoints = 0
+		t.mutex.Unlock()
+
+		t.Restart("failing back to " + primary)
+	}
+}
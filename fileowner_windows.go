@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// copyOwner does nothing: Windows files have no uid and gid to carry over
+func copyOwner(path string, info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Default timings for application-level health checks
+const (
+	defaultHealthCheckInterval = 30 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+)
+
+// HealthCheckConfig describes an application-level probe run against a tunnel's local port.
+// The SSH listener accepts connections even when the remote target is down, so these
+// probes speak enough of the target protocol to tell whether something real answers.
+type HealthCheckConfig struct {
+	Type           string   `json:"type"`                     // "tcp", "http", "banner", "postgres", "redis", "mysql"
+	Path           string   `json:"path,omitempty"`           // HTTP request path (default "/")
+	ExpectedStatus int      `json:"expectedStatus,omitempty"` // HTTP status to expect (default: any status below 400)
+	Expect         string   `json:"expect,omitempty"`         // Regular expression the banner must match
+	Interval       Duration `json:"interval,omitempty"`       // Time between checks (default 30s)
+	Timeout        Duration `json:"timeout,omitempty"`        // Per-check timeout (default 5s)
+}
+
+// HealthCheckResult is the outcome of the most recent health check
+type HealthCheckResult struct {
+	Type      string    `json:"type"`
+	Healthy   bool      `json:"healthy"`
+	Message   string    `json:"message"`
+	Latency   string    `json:"latency"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// healthProbes maps probe types to their implementations
+var healthProbes = map[string]func(HealthCheckConfig, string, time.Duration) error{
+	"tcp":      probeTCP,
+	"http":     probeHTTP,
+	"banner":   probeBanner,
+	"postgres": probePostgres,
+	"redis":    probeRedis,
+	"mysql":    probeMySQL,
+}
+
+// validateHealthCheck checks that a health check configuration is usable
+func validateHealthCheck(hc *HealthCheckConfig) error {
+	if hc == nil {
+		return nil
+	}
+	if _, ok := healthProbes[hc.Type]; !ok {
+		return fmt.Errorf("unknown health check type %q", hc.Type)
+	}
+	if hc.Expect != "" {
+		if _, err := regexp.Compile(hc.Expect); err != nil {
+			return fmt.Errorf("invalid health check expect pattern: %v", err)
+		}
+	}
+	if hc.Interval < 0 || hc.Timeout < 0 {
+		return fmt.Errorf("health check interval and timeout must not be negative")
+	}
+	return nil
+}
+
+// interval returns the configured check interval or the default
+func (hc *HealthCheckConfig) interval() time.Duration {
+	if hc == nil || hc.Interval <= 0 {
+		return defaultHealthCheckInterval
+	}
+	return time.Duration(hc.Interval)
+}
+
+// timeout returns the configured check timeout or the default
+func (hc *HealthCheckConfig) timeout() time.Duration {
+	if hc == nil || hc.Timeout <= 0 {
+		return defaultHealthCheckTimeout
+	}
+	return time.Duration(hc.Timeout)
+}
+
+// runHealthCheck runs the configured probe against addr and reports the result
+func runHealthCheck(hc HealthCheckConfig, addr string) HealthCheckResult {
+	start := time.Now()
+	result := HealthCheckResult{
+		Type:      hc.Type,
+		CheckedAt: start,
+	}
+
+	probe, ok := healthProbes[hc.Type]
+	if !ok {
+		result.Message = fmt.Sprintf("unknown health check type %q", hc.Type)
+		return result
+	}
+
+	err := probe(hc, addr, hc.timeout())
+	result.Latency = time.Since(start).Round(time.Millisecond).String()
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+
+	result.Healthy = true
+	result.Message = "OK"
+	return result
+}
+
+// dialProbe opens a connection for a probe with an overall deadline
+func dialProbe(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("connect failed: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	return conn, nil
+}
+
+// probeTCP only verifies that the local port accepts connections
+func probeTCP(_ HealthCheckConfig, addr string, timeout time.Duration) error {
+	conn, err := dialProbe(addr, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// probeHTTP issues a GET request and checks the response status
+func probeHTTP(hc HealthCheckConfig, addr string, timeout time.Duration) error {
+	path := hc.Path
+	if path == "" {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(fmt.Sprintf("http://%s%s", addr, path))
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if hc.ExpectedStatus != 0 {
+		if resp.StatusCode != hc.ExpectedStatus {
+			return fmt.Errorf("HTTP status %d, expected %d", resp.StatusCode, hc.ExpectedStatus)
+		}
+		return nil
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// probeBanner reads the greeting a server sends on connect and matches it against Expect
+func probeBanner(hc HealthCheckConfig, addr string, timeout time.Duration) error {
+	conn, err := dialProbe(addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if n == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return fmt.Errorf("no banner received: %v", err)
+	}
+
+	banner := string(buf[:n])
+	if hc.Expect != "" {
+		re, err := regexp.Compile(hc.Expect)
+		if err != nil {
+			return fmt.Errorf("invalid expect pattern: %v", err)
+		}
+		if !re.MatchString(banner) {
+			return fmt.Errorf("banner %q does not match %q", strings.TrimSpace(banner), hc.Expect)
+		}
+	}
+	return nil
+}
+
+// probePostgres sends an SSLRequest, which every PostgreSQL server answers with a
+// single 'S' or 'N' byte before any authentication happens
+func probePostgres(_ HealthCheckConfig, addr string, timeout time.Duration) error {
+	conn, err := dialProbe(addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], 80877103)
+	if _, err := conn.Write(request); err != nil {
+		return fmt.Errorf("failed to send SSLRequest: %v", err)
+	}
+
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("no PostgreSQL handshake response: %v", err)
+	}
+	switch reply[0] {
+	case 'S', 'N':
+		return nil
+	case 'E':
+		return fmt.Errorf("PostgreSQL server returned an error during handshake")
+	default:
+		return fmt.Errorf("unexpected PostgreSQL handshake response 0x%02x", reply[0])
+	}
+}
+
+// probeRedis sends PING and expects PONG; an authentication error still proves Redis is answering
+func probeRedis(_ HealthCheckConfig, addr string, timeout time.Duration) error {
+	conn, err := dialProbe(addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil {
+		return fmt.Errorf("failed to send PING: %v", err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("no reply to PING: %v", err)
+	}
+	line = strings.TrimSpace(line)
+
+	switch {
+	case line == "+PONG":
+		return nil
+	case strings.HasPrefix(line, "-NOAUTH"), strings.HasPrefix(line, "-WRONGPASS"):
+		return nil
+	default:
+		return fmt.Errorf("unexpected reply to PING: %q", line)
+	}
+}
+
+// probeMySQL reads the initial handshake packet MySQL sends on connect
+func probeMySQL(_ HealthCheckConfig, addr string, timeout time.Duration) error {
+	conn, err := dialProbe(addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("no MySQL handshake received: %v", err)
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if length == 0 || length > 64*1024 {
+		return fmt.Errorf("invalid MySQL handshake length %d", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return fmt.Errorf("truncated MySQL handshake: %v", err)
+	}
+
+	switch payload[0] {
+	case 0x0a:
+		return nil
+	case 0xff:
+		message := ""
+		if len(payload) > 3 {
+			message = string(payload[3:])
+		}
+		return fmt.Errorf("MySQL server refused connection: %s", strings.TrimSpace(message))
+	default:
+		return fmt.Errorf("unsupported MySQL protocol version %d", payload[0])
+	}
+}
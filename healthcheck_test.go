@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer accepts connections on a loopback port and hands each to handle
+func fakeServer(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// replyWith returns a handler that reads request bytes, if any, and writes reply
+func replyWith(request int, reply string) func(net.Conn) {
+	return func(conn net.Conn) {
+		if request > 0 {
+			io.ReadFull(conn, make([]byte, request))
+		}
+		conn.Write([]byte(reply))
+	}
+}
+
+// closedAddress returns a loopback address nothing listens on
+func closedAddress(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestValidateHealthCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		hc      *HealthCheckConfig
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"tcp", &HealthCheckConfig{Type: "tcp"}, false},
+		{"banner with pattern", &HealthCheckConfig{Type: "banner", Expect: "^SSH-"}, false},
+		{"unknown type", &HealthCheckConfig{Type: "ftp"}, true},
+		{"bad pattern", &HealthCheckConfig{Type: "banner", Expect: "("}, true},
+		{"negative interval", &HealthCheckConfig{Type: "tcp", Interval: Duration(-time.Second)}, true},
+		{"negative timeout", &HealthCheckConfig{Type: "tcp", Timeout: Duration(-time.Second)}, true},
+	}
+	for _, tt := range tests {
+		if err := validateHealthCheck(tt.hc); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateHealthCheck() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHealthCheckDefaults(t *testing.T) {
+	var hc *HealthCheckConfig
+	if hc.interval() != defaultHealthCheckInterval || hc.timeout() != defaultHealthCheckTimeout {
+		t.Errorf("nil config: interval %s, timeout %s", hc.interval(), hc.timeout())
+	}
+	hc = &HealthCheckConfig{Interval: Duration(time.Minute), Timeout: Duration(time.Second)}
+	if hc.interval() != time.Minute || hc.timeout() != time.Second {
+		t.Errorf("configured: interval %s, timeout %s", hc.interval(), hc.timeout())
+	}
+}
+
+func TestProbeHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.WriteHeader(http.StatusOK)
+		case "/teapot":
+			w.WriteHeader(http.StatusTeapot)
+		case "/moved":
+			http.Redirect(w, r, "/missing", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		name    string
+		hc      HealthCheckConfig
+		addr    string
+		healthy bool
+	}{
+		{"default path", HealthCheckConfig{Type: "http"}, addr, true},
+		{"path without slash", HealthCheckConfig{Type: "http", Path: "teapot", ExpectedStatus: 418}, addr, true},
+		{"error status", HealthCheckConfig{Type: "http", Path: "/missing"}, addr, false},
+		{"unexpected status", HealthCheckConfig{Type: "http", ExpectedStatus: 204}, addr, false},
+		{"redirect is not followed", HealthCheckConfig{Type: "http", Path: "/moved"}, addr, true},
+		{"redirect status", HealthCheckConfig{Type: "http", Path: "/moved", ExpectedStatus: 302}, addr, true},
+		{"nothing listening", HealthCheckConfig{Type: "http"}, closedAddress(t), false},
+	}
+	for _, tt := range tests {
+		if result := runHealthCheck(tt.hc, tt.addr); result.Healthy != tt.healthy {
+			t.Errorf("%s: healthy = %v (%s), want %v", tt.name, result.Healthy, result.Message, tt.healthy)
+		}
+	}
+}
+
+func TestProbes(t *testing.T) {
+	mysqlGreeting := "\x05\x00\x00\x00\x0a8.0\x00"
+	mysqlError := "\x08\x00\x00\x00\xff\x6a\x04Denied"
+
+	tests := []struct {
+		name    string
+		hc      HealthCheckConfig
+		handle  func(net.Conn)
+		healthy bool
+	}{
+		{"tcp open", HealthCheckConfig{Type: "tcp"}, func(net.Conn) {}, true},
+		{"banner", HealthCheckConfig{Type: "banner", Expect: "^SSH-2.0"}, replyWith(0, "SSH-2.0-OpenSSH_9.2\r\n"), true},
+		{"banner mismatch", HealthCheckConfig{Type: "banner", Expect: "^SSH-"}, replyWith(0, "220 smtp ready\r\n"), false},
+		{"banner any", HealthCheckConfig{Type: "banner"}, replyWith(0, "hello"), true},
+		{"no banner", HealthCheckConfig{Type: "banner"}, func(net.Conn) {}, false},
+		{"postgres with TLS", HealthCheckConfig{Type: "postgres"}, replyWith(8, "S"), true},
+		{"postgres without TLS", HealthCheckConfig{Type: "postgres"}, replyWith(8, "N"), true},
+		{"postgres error", HealthCheckConfig{Type: "postgres"}, replyWith(8, "E"), false},
+		{"postgres garbage", HealthCheckConfig{Type: "postgres"}, replyWith(8, "H"), false},
+		{"postgres closed", HealthCheckConfig{Type: "postgres"}, replyWith(8, ""), false},
+		{"redis", HealthCheckConfig{Type: "redis"}, replyWith(14, "+PONG\r\n"), true},
+		{"redis needs auth", HealthCheckConfig{Type: "redis"}, replyWith(14, "-NOAUTH Authentication required.\r\n"), true},
+		{"redis wrong password", HealthCheckConfig{Type: "redis"}, replyWith(14, "-WRONGPASS invalid password\r\n"), true},
+		{"redis error", HealthCheckConfig{Type: "redis"}, replyWith(14, "-ERR unknown command\r\n"), false},
+		{"redis closed", HealthCheckConfig{Type: "redis"}, replyWith(14, ""), false},
+		{"mysql", HealthCheckConfig{Type: "mysql"}, replyWith(0, mysqlGreeting), true},
+		{"mysql refused", HealthCheckConfig{Type: "mysql"}, replyWith(0, mysqlError), false},
+		{"mysql old protocol", HealthCheckConfig{Type: "mysql"}, replyWith(0, "\x01\x00\x00\x00\x09"), false},
+		{"mysql empty packet", HealthCheckConfig{Type: "mysql"}, replyWith(0, "\x00\x00\x00\x00"), false},
+		{"mysql truncated", HealthCheckConfig{Type: "mysql"}, replyWith(0, "\x05\x00\x00\x00\x0a"), false},
+	}
+	for _, tt := range tests {
+		tt.hc.Timeout = Duration(2 * time.Second)
+		addr := fakeServer(t, tt.handle)
+		result := runHealthCheck(tt.hc, addr)
+		if result.Healthy != tt.healthy {
+			t.Errorf("%s: healthy = %v (%s), want %v", tt.name, result.Healthy, result.Message, tt.healthy)
+		}
+		if result.Type != tt.hc.Type || result.Latency == "" || result.CheckedAt.IsZero() {
+			t.Errorf("%s: incomplete result %+v", tt.name, result)
+		}
+	}
+}
+
+func TestProbeUnreachable(t *testing.T) {
+	addr := closedAddress(t)
+	for probe := range healthProbes {
+		hc := HealthCheckConfig{Type: probe, Timeout: Duration(time.Second)}
+		if result := runHealthCheck(hc, addr); result.Healthy {
+			t.Errorf("%s: healthy with nothing listening", probe)
+		}
+	}
+}
+
+func TestProbeTimeout(t *testing.T) {
+	// A server that accepts but never answers must not hang the check
+	addr := fakeServer(t, func(conn net.Conn) { time.Sleep(2 * time.Second) })
+	hc := HealthCheckConfig{Type: "redis", Timeout: Duration(200 * time.Millisecond)}
+
+	start := time.Now()
+	if result := runHealthCheck(hc, addr); result.Healthy {
+		t.Errorf("healthy without a reply")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("check took %s despite a 200ms timeout", elapsed)
+	}
+}
+
+func TestRunHealthCheckUnknownType(t *testing.T) {
+	if result := runHealthCheck(HealthCheckConfig{Type: "ftp"}, "127.0.0.1:1"); result.Healthy || result.Message == "" {
+		t.Errorf("unknown type gave %+v", result)
+	}
+}
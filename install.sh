@@ -57,7 +57,7 @@ build_app() {
     print_status "Building Easy SSH Tunnel Manager..."
     
     if [ -f "main.go" ]; then
-        go build -o "$BINARY_NAME" main.go
+        go build -o "$BINARY_NAME" .
         if [ $? -eq 0 ]; then
             print_success "Build completed successfully"
         else
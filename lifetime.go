@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// lifetimeCheckInterval is how often idle timeouts and lifetimes are checked
+const lifetimeCheckInterval = 10 * time.Second
+
+// validateLifetime checks the automatic shutdown settings
+func validateLifetime(config TunnelConfig) error {
+	if config.IdleTimeout < 0 || config.MaxLifetime < 0 {
+		return fmt.Errorf("idleTimeout and maxLifetime must not be negative")
+	}
+	if config.Enabled && config.ExpiresAt != nil && !config.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expiresAt %s is in the past", config.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// autoStop returns when and why the tunnel will be disabled automatically, or a zero
+// time if it runs indefinitely. The caller must hold t.mutex.
+func (t *Tunnel) autoStop() (time.Time, string) {
+	if !t.config.Enabled || t.cancel == nil {
+		return time.Time{}, ""
+	}
+
+	var at time.Time
+	var reason string
+	consider := func(deadline time.Time, why string) {
+		if at.IsZero() || deadline.Before(at) {
+			at, reason = deadline, why
+		}
+	}
+
+	if t.config.ExpiresAt != nil {
+		consider(*t.config.ExpiresAt, "expired at "+t.config.ExpiresAt.Local().Format(time.RFC3339))
+	}
+	if t.config.MaxLifetime > 0 && !t.startedAt.IsZero() {
+		lifetime := time.Duration(t.config.MaxLifetime)
+		consider(t.startedAt.Add(lifetime), fmt.Sprintf("maximum lifetime of %s reached", lifetime))
+	}
+	if t.config.IdleTimeout > 0 && !t.lastActivity.IsZero() {
+		idle := time.Duration(t.config.IdleTimeout)
+		consider(t.lastActivity.Add(idle), fmt.Sprintf("no traffic for %s", idle))
+	}
+	return at, reason
+}
+
+// observeActivity records traffic through the tunnel since the last check. Managed
+// listeners count bytes; for ports owned by ssh, open client connections count.
+func (t *Tunnel) observeActivity() {
+	t.mutex.RLock()
+	watch := t.config.IdleTimeout > 0 && t.cancel != nil
+	managed := t.isManaged()
+	host, port := t.bindHost(), t.activePort()
+	t.mutex.RUnlock()
+
+	if !watch {
+		return
+	}
+
+	// The socket scan runs unlocked; the counters are compared and recorded under the lock
+	active := false
+	if !managed {
+		active = hasClientConnections(host, port)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if managed {
+		bytes := t.traffic.bytesIn.Load() + t.traffic.bytesOut.Load()
+		active = t.traffic.active.Load() > 0 || t.traffic.held.Load() > 0 || bytes != t.observedBytes
+		t.observedBytes = bytes
+	}
+	if active {
+		t.lastActivity = time.Now()
+	}
+}
+
+// hasClientConnections reports whether any established connection uses the local
+// port on host, i.e. a client is talking through ssh's forward
+func hasClientConnections(host, port string) bool {
+	sockets, err := findPortSockets(port)
+	if err != nil {
+		return true // Unknown counts as active so a tunnel is never stopped by mistake
+	}
+	for _, socket := range sockets {
+		if socket.State != "ESTABLISHED" {
+			continue
+		}
+		if _, localPort, err := net.SplitHostPort(socket.LocalAddress); err == nil && localPort == port && listenerConflicts(socket, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchLifetimes disables tunnels whose expiry, maximum lifetime or idle timeout is reached
+func (tm *TunnelManager) watchLifetimes(ctx context.Context) {
+	ticker := time.NewTicker(lifetimeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		tm.mutex.RLock()
+		tunnels := make([]*Tunnel, 0, len(tm.tunnels))
+		for _, tunnel := range tm.tunnels {
+			tunnels = append(tunnels, tunnel)
+		}
+		tm.mutex.RUnlock()
+
+		for _, tunnel := range tunnels {
+			tunnel.observeActivity()
+
+			tunnel.mutex.RLock()
+			at, reason := tunnel.autoStop()
+			tunnel.mutex.RUnlock()
+
+			if !at.IsZero() && !time.Now().Before(at) {
+				tm.expireTunnel(tunnel, reason)
+			}
+		}
+	}
+}
+
+// expireTunnel disables a tunnel that reached its automatic shutdown, persists that
+// and tells the UI why
+func (tm *TunnelManager) expireTunnel(tunnel *Tunnel, reason string) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	name := tunnel.config.Name
+	if tm.tunnels[name] != tunnel {
+		return
+	}
+
+	// A passed expiry is cleared so the tunnel can simply be enabled again
+	tunnel.mutex.Lock()
+	if !tunnel.config.Enabled {
+		tunnel.mutex.Unlock()
+		return
+	}
+	tunnel.config.Enabled = false
+	tunnel.config.ExpiresAt = nil
+	tunnel.mutex.Unlock()
+	tunnel.StopWithReason("auto-stopped: " + reason)
+
+	tm.saveConfig()
+	tm.notifyStatusChanged()
+
+	log.Printf("Tunnel '%s' disabled automatically: %s", name, reason)
+	tm.BroadcastSSE("tunnel_auto_stopped", map[string]interface{}{
+		"tunnel":    name,
+		"reason":    reason,
+		"timestamp": time.Now().UTC(),
+	})
+}
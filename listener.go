@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Listener modes decide who owns the tunnel's local port
+const (
+	ListenerSSH     = "ssh"     // ssh binds the local port itself (default)
+	ListenerManaged = "managed" // easytunnel binds it and relays to an internal ssh forward
+)
+
+// forwardHost is where ssh binds its internal forward for managed listeners
+const forwardHost = "127.0.0.1"
+
+// forwardDialTimeout bounds connecting a client to ssh's internal forward
+const forwardDialTimeout = 5 * time.Second
+
+// validateListener checks the listener mode
+func validateListener(config TunnelConfig) error {
+	switch config.Listener {
+	case "", ListenerSSH, ListenerManaged:
+	default:
+		return fmt.Errorf("unknown listener mode %q", config.Listener)
+	}
+
+	if config.HoldConnections < 0 {
+		return fmt.Errorf("holdConnections must not be negative")
+	}
+	if config.HoldConnections > 0 && config.Listener != ListenerManaged && !config.OnDemand {
+		return fmt.Errorf("holdConnections requires the %q listener", ListenerManaged)
+	}
+	return nil
+}
+
+// TrafficStats summarizes connections relayed by a managed listener. BytesIn counts
+// bytes sent by local clients into the tunnel, BytesOut the bytes sent back.
+type TrafficStats struct {
+	BytesIn           int64     `json:"bytesIn"`
+	BytesOut          int64     `json:"bytesOut"`
+	ActiveConnections int64     `json:"activeConnections"`
+	HeldConnections   int64     `json:"heldConnections"`
+	TotalConnections  int64     `json:"totalConnections"`
+	FailedConnections int64     `json:"failedConnections"`
+	AverageDuration   string    `json:"averageDuration,omitempty"`
+	LongestDuration   string    `json:"longestDuration,omitempty"`
+	LastConnectionAt  time.Time `json:"lastConnectionAt"`
+}
+
+// trafficCounters accumulates TrafficStats; the counters are updated without the tunnel lock
+type trafficCounters struct {
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+	active   atomic.Int64
+	held     atomic.Int64
+	total    atomic.Int64
+	failed   atomic.Int64
+
+	mutex     sync.Mutex
+	completed int64
+	duration  time.Duration
+	longest   time.Duration
+	last      time.Time
+}
+
+// opened records a new client connection
+func (c *trafficCounters) opened() {
+	c.active.Add(1)
+	c.total.Add(1)
+	c.mutex.Lock()
+	c.last = time.Now()
+	c.mutex.Unlock()
+}
+
+// closed records the end of a client connection that lasted d
+func (c *trafficCounters) closed(d time.Duration) {
+	c.active.Add(-1)
+	c.mutex.Lock()
+	c.completed++
+	c.duration += d
+	if d > c.longest {
+		c.longest = d
+	}
+	c.mutex.Unlock()
+}
+
+// snapshot returns the current counters
+func (c *trafficCounters) snapshot() *TrafficStats {
+	stats := &TrafficStats{
+		BytesIn:           c.bytesIn.Load(),
+		BytesOut:          c.bytesOut.Load(),
+		ActiveConnections: c.active.Load(),
+		HeldConnections:   c.held.Load(),
+		TotalConnections:  c.total.Load(),
+		FailedConnections: c.failed.Load(),
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats.LastConnectionAt = c.last
+	if c.completed > 0 {
+		stats.AverageDuration = (c.duration / time.Duration(c.completed)).Round(time.Millisecond).String()
+		stats.LongestDuration = c.longest.Round(time.Millisecond).String()
+	}
+	return stats
+}
+
+// countingWriter adds every byte written to a counter
+type countingWriter struct {
+	w     io.Writer
+	count *atomic.Int64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.count.Add(int64(n))
+	return n, err
+}
+
+// managedListener is the local port easytunnel listens on in managed mode
+type managedListener struct {
+	ln         net.Listener
+	host       string // Bind address it was opened for
+	configPort string // Configured local port it was opened for
+	port       string // Port actually listened on
+}
+
+// isManaged reports whether easytunnel owns the tunnel's local port
+func (t *Tunnel) isManaged() bool {
+	return t.config.Listener == ListenerManaged || t.config.OnDemand
+}
+
+// reusableListener returns the port of a listener still open from an earlier session
+// of the same configuration, or "". A listener that no longer matches is closed.
+func (t *Tunnel) reusableListener() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	l := t.listener
+	if l == nil {
+		return ""
+	}
+	if t.isManaged() && l.host == t.bindHost() && l.configPort == t.config.LocalPort {
+		return l.port
+	}
+	t.closeListener()
+	return ""
+}
+
+// prepareManaged opens the local listener unless it is still open and picks a fresh
+// loopback port for ssh's forward. The caller must hold t.mutex.
+func (t *Tunnel) prepareManaged(port string) error {
+	if t.listener == nil {
+		if err := t.openListener(port); err != nil {
+			return fmt.Errorf("cannot listen on port %s: %v", port, err)
+		}
+	}
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(forwardHost, "0"))
+	if err != nil {
+		return fmt.Errorf("cannot allocate an internal forward port: %v", err)
+	}
+	t.forwardPort = strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+	return nil
+}
+
+// openListener starts accepting clients on the tunnel's local address. The caller
+// must hold t.mutex.
+func (t *Tunnel) openListener(port string) error {
+	ln, err := net.Listen("tcp", net.JoinHostPort(listenHost(t.bindHost()), port))
+	if err != nil {
+		return err
+	}
+	t.listener = &managedListener{ln: ln, host: t.bindHost(), configPort: t.config.LocalPort, port: port}
+	log.Printf("Tunnel '%s' listening on %s", t.config.Name, ln.Addr())
+
+	go t.acceptClients(ln)
+	return nil
+}
+
+// closeListener stops accepting clients; connections already relayed keep running.
+// The caller must hold t.mutex.
+func (t *Tunnel) closeListener() {
+	if t.listener == nil {
+		return
+	}
+	t.listener.ln.Close()
+	t.listener = nil
+	log.Printf("Tunnel '%s' stopped listening", t.config.Name)
+}
+
+// acceptClients relays every client accepted on ln until the listener is closed
+func (t *Tunnel) acceptClients(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go t.relay(conn)
+	}
+}
+
+// relay connects a client to ssh's internal forward and copies data both ways,
+// counting bytes and timing the connection
+func (t *Tunnel) relay(client net.Conn) {
+	defer client.Close()
+
+	target, ok := t.awaitForward()
+	if !ok {
+		t.traffic.failed.Add(1)
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", target, forwardDialTimeout)
+	if err != nil {
+		t.traffic.failed.Add(1)
+		log.Printf("Tunnel '%s' could not relay connection from %s: %v", t.config.Name, client.RemoteAddr(), err)
+		return
+	}
+	defer upstream.Close()
+
+	started := time.Now()
+	t.traffic.opened()
+	defer func() { t.traffic.closed(time.Since(started)) }()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(countingWriter{upstream, &t.traffic.bytesIn}, client)
+		closeWrite(upstream)
+		close(done)
+	}()
+	io.Copy(countingWriter{client, &t.traffic.bytesOut}, upstream)
+	closeWrite(client)
+	<-done
+}
+
+// awaitForward returns the address of ssh's forward. While ssh is reconnecting, or
+// starting for an on-demand tunnel, the client is held for up to holdConnections;
+// ok is false if the tunnel is not ready by then.
+func (t *Tunnel) awaitForward() (string, bool) {
+	t.mutex.RLock()
+	up := t.isReady()
+	ready := t.ready
+	hold := t.holdTimeout()
+	target := t.forwardAddress()
+	t.mutex.RUnlock()
+
+	if up {
+		return target, true
+	}
+	if t.isOnDemand() {
+		t.Activate()
+	}
+	if hold <= 0 || ready == nil {
+		return "", false
+	}
+
+	t.traffic.held.Add(1)
+	defer t.traffic.held.Add(-1)
+
+	timer := time.NewTimer(hold)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+	case <-timer.C:
+		log.Printf("Tunnel '%s' dropped a held connection after %s", t.config.Name, hold)
+		return "", false
+	}
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.forwardAddress(), t.isReady()
+}
+
+// isReady reports whether ssh's forward can take connections. The caller must hold t.mutex.
+func (t *Tunnel) isReady() bool {
+	return t.status == StateConnected || t.status == StateUnhealthy
+}
+
+// signalReady closes the ready channel when the tunnel becomes usable, releasing held
+// clients, and replaces it once the tunnel stops being usable. The caller must hold t.mutex.
+func (t *Tunnel) signalReady() {
+	if t.ready == nil {
+		return
+	}
+	select {
+	case <-t.ready:
+		if !t.isReady() {
+			t.ready = make(chan struct{})
+		}
+	default:
+		if t.isReady() {
+			close(t.ready)
+		}
+	}
+}
+
+// closeWrite half-closes a TCP connection so the peer sees EOF while replies can still arrive
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	} else {
+		conn.Close()
+	}
+}
+
+// forwardBinding is the host and port of ssh's forward: the internal loopback port
+// when easytunnel owns the listener, otherwise the tunnel's local address
+func (t *Tunnel) forwardBinding() (string, string) {
+	if t.isManaged() && t.forwardPort != "" {
+		return forwardHost, t.forwardPort
+	}
+	return t.bindHost(), t.activePort()
+}
+
+// forwardAddress is the address used to reach ssh's forward from this machine
+func (t *Tunnel) forwardAddress() string {
+	host, port := t.forwardBinding()
+	return net.JoinHostPort(dialHost(host), port)
+}
+
+// trafficStats returns the relay counters, or nil when ssh owns the local port.
+// The caller must hold t.mutex.
+func (t *Tunnel) trafficStats() *TrafficStats {
+	if !t.isManaged() {
+		return nil
+	}
+	return t.traffic.snapshot()
+}
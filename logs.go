@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Per-tunnel log limits
+const (
+	maxLogEntries     = 500 // Entries kept in each tunnel's log
+	maxStderrLines    = 20  // Lines of ssh output kept for error messages
+	logSubscriberSize = 100 // Entries a slow follower may fall behind before losing some
+)
+
+// Log entry sources
+const (
+	LogSSH       = "ssh"       // A line ssh wrote to stderr
+	LogLifecycle = "lifecycle" // A state transition or manager action
+	LogHealth    = "health"    // A health check result
+)
+
+// LogEntry is one line in a tunnel's log
+type LogEntry struct {
+	Seq     int64     `json:"seq"`
+	At      time.Time `json:"at"`
+	Source  string    `json:"source"`
+	Message string    `json:"message"`
+}
+
+// tunnelLog is a bounded log of recent tunnel activity that can be followed live
+type tunnelLog struct {
+	mutex       sync.Mutex
+	entries     []LogEntry
+	seq         int64
+	subscribers map[chan LogEntry]bool
+}
+
+// add appends an entry, dropping the oldest once the log is full, and passes it to
+// followers without blocking
+func (l *tunnelLog) add(source, message string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.seq++
+	entry := LogEntry{Seq: l.seq, At: time.Now().UTC(), Source: source, Message: message}
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > maxLogEntries {
+		l.entries = l.entries[len(l.entries)-maxLogEntries:]
+	}
+
+	for subscriber := range l.subscribers {
+		select {
+		case subscriber <- entry:
+		default:
+			// Follower is too slow, skip
+		}
+	}
+}
+
+// tail returns up to n of the most recent entries, oldest first; n <= 0 means all
+func (l *tunnelLog) tail(n int) []LogEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.tailLocked(n)
+}
+
+func (l *tunnelLog) tailLocked(n int) []LogEntry {
+	entries := l.entries
+	if n > 0 && len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return append([]LogEntry{}, entries...)
+}
+
+// follow returns the most recent entries and a channel receiving every later one,
+// with nothing lost in between. Call unfollow when done.
+func (l *tunnelLog) follow(n int) ([]LogEntry, chan LogEntry) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.subscribers == nil {
+		l.subscribers = make(map[chan LogEntry]bool)
+	}
+	subscriber := make(chan LogEntry, logSubscriberSize)
+	l.subscribers[subscriber] = true
+	return l.tailLocked(n), subscriber
+}
+
+// unfollow stops delivering entries to a follower
+func (l *tunnelLog) unfollow(subscriber chan LogEntry) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.subscribers, subscriber)
+}
+
+// logTransition records a state change or action in the tunnel log
+func (t *Tunnel) logTransition(transition TunnelTransition) {
+	if transition.Action != "" {
+		t.logs.add(LogLifecycle, fmt.Sprintf("%s: %s", transition.Action, transition.Reason))
+		return
+	}
+	t.logs.add(LogLifecycle, fmt.Sprintf("%s -> %s: %s", transition.From, transition.To, transition.Reason))
+}
+
+// logHealth records a health check result in the tunnel log and the process log
+func (t *Tunnel) logHealth(passed bool, detail string) {
+	outcome := "failed"
+	if passed {
+		outcome = "passed"
+	}
+	if detail == "" {
+		t.logs.add(LogHealth, "health check "+outcome)
+		log.Printf("Health check %s for tunnel '%s'", outcome, t.config.Name)
+		return
+	}
+	t.logs.add(LogHealth, fmt.Sprintf("health check %s: %s", outcome, detail))
+	if passed {
+		log.Printf("Health check passed for tunnel '%s' (%s)", t.config.Name, detail)
+	} else {
+		log.Printf("Health check failed for tunnel '%s': %s", t.config.Name, detail)
+	}
+}
+
+// debug reports whether ssh runs with -v, for this tunnel or for all of them
+func (t *Tunnel) debug() bool {
+	return t.config.Debug || (t.manager != nil && t.manager.settings.Debug)
+}
+
+// sshOutput splits ssh's stderr into lines for the log and keeps the last lines that
+// are not -v debug output for error messages
+type sshOutput struct {
+	mutex   sync.Mutex
+	partial []byte
+	kept    []string
+	emit    func(line string)
+}
+
+// newSSHOutput returns stderr for an ssh process whose lines go to the tunnel log
+func (t *Tunnel) newSSHOutput() *sshOutput {
+	return &sshOutput{emit: func(line string) {
+		t.logs.add(LogSSH, line)
+	}}
+}
+
+func (o *sshOutput) Write(p []byte) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.partial = append(o.partial, p...)
+	for {
+		i := bytes.IndexByte(o.partial, '\n')
+		if i < 0 {
+			break
+		}
+		o.line(string(o.partial[:i]))
+		o.partial = o.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// line handles one complete line. The caller must hold o.mutex.
+func (o *sshOutput) line(line string) {
+	line = strings.TrimRight(line, "\r")
+	if line == "" {
+		return
+	}
+	if o.emit != nil {
+		o.emit(line)
+	}
+	if strings.HasPrefix(line, "debug") {
+		return // -v output is only for the log
+	}
+	o.kept = append(o.kept, line)
+	if len(o.kept) > maxStderrLines {
+		o.kept = o.kept[len(o.kept)-maxStderrLines:]
+	}
+}
+
+// String returns the kept lines, first handling a final line without a newline
+func (o *sshOutput) String() string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if len(o.partial) > 0 {
+		o.line(string(o.partial))
+		o.partial = nil
+	}
+	return strings.Join(o.kept, "\n")
+}
+
+// GetLogs returns a tunnel's log
+func (tm *TunnelManager) GetLogs(name string) (*tunnelLog, error) {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	tunnel, exists := tm.tunnels[name]
+	if !exists {
+		return nil, fmt.Errorf("tunnel not found: %s", name)
+	}
+	return &tunnel.logs, nil
+}
+
+// streamLogs sends the most recent entries of a log followed by new ones as
+// server-sent events until the client goes away
+func streamLogs(w http.ResponseWriter, r *http.Request, l *tunnelLog, lines int) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	entries, subscriber := l.follow(lines)
+	defer l.unfollow(subscriber)
+
+	for _, entry := range entries {
+		data, _ := json.Marshal(entry)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry := <-subscriber:
+			data, _ := json.Marshal(entry)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
@@ -14,7 +14,6 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -42,17 +41,107 @@ type TunnelConfig struct {
 	LocalPort     string `json:"localPort"`
 	Enabled       bool   `json:"enabled"`
 	AutoExtracted bool   `json:"autoExtracted"`
+
+	HealthCheck *HealthCheckConfig `json:"healthCheck,omitempty"`
+	Retry       *RetryPolicy       `json:"retry,omitempty"`
+
+	Preconditions []Precondition `json:"preconditions,omitempty"`
+
+	PortPolicy     string `json:"portPolicy,omitempty"`     // What to do when the local port is taken
+	ConfirmKillAny bool   `json:"confirmKillAny,omitempty"` // Required for the kill-any port policy
+
+	BindAddress string `json:"bindAddress,omitempty"` // Local address ssh binds, e.g. 127.0.0.2
+	Allocate    string `json:"allocate,omitempty"`    // "loopback" or "port" to have one assigned
+
+	Listener        string   `json:"listener,omitempty"`        // "managed" to have easytunnel own the local port
+	HoldConnections Duration `json:"holdConnections,omitempty"` // How long a managed listener holds clients while ssh reconnects
+
+	OnDemand     bool     `json:"onDemand,omitempty"`     // Start ssh on the first inbound connection
+	OnDemandIdle Duration `json:"onDemandIdle,omitempty"` // Close the ssh session after this long without connections
+
+	IdleTimeout Duration   `json:"idleTimeout,omitempty"` // Disable the tunnel after this long without traffic
+	MaxLifetime Duration   `json:"maxLifetime,omitempty"` // Disable the tunnel this long after it was started
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`   // Disable the tunnel at this time
+
+	Schedule *Schedule `json:"schedule,omitempty"` // Only run inside these windows
+
+	DependsOn []string `json:"dependsOn,omitempty"` // Tunnels that must be connected first
+
+	Tags []string `json:"tags,omitempty"` // Labels for filtering and bulk actions, e.g. "db"
+
+	Endpoints         []string `json:"endpoints,omitempty"`         // Alternative bastions, used instead of the command's host
+	EndpointSelection string   `json:"endpointSelection,omitempty"` // "priority" (default) or "round-robin"
+
+	Multiplex bool `json:"multiplex,omitempty"` // Share one ssh connection per host with other multiplexed tunnels
+
+	Debug bool `json:"debug,omitempty"` // Run ssh with -v; its output goes to the tunnel log
+}
+
+// Duration is a time.Duration that is written to JSON as a string such as "30s".
+// Plain numbers are accepted when reading and are interpreted as seconds.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\" or a number of seconds")
+	}
+	if value == "" {
+		*d = 0
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
 }
 
 // TunnelStatus represents the status of a tunnel
 type TunnelStatus struct {
 	Config          TunnelConfig `json:"config"`
-	Status          string       `json:"status"` // "connected", "disconnected", "connecting", "error"
+	Status          TunnelState  `json:"status"`
+	StateReason     string       `json:"stateReason"`
 	LastError       string       `json:"lastError"`
 	ConnectedAt     time.Time    `json:"connectedAt"`
 	Uptime          string       `json:"uptime"`
 	PID             int          `json:"pid"`
 	LastHealthCheck string       `json:"lastHealthCheck"`
+
+	HealthCheck *HealthCheckResult `json:"healthCheck,omitempty"`
+	Attempt     int                `json:"attempt"`
+	MaxAttempts int                `json:"maxAttempts"`
+	NextRetryAt time.Time          `json:"nextRetryAt"`
+	Endpoint    *SSHEndpoint       `json:"endpoint,omitempty"`
+	BoundPort   string             `json:"boundPort,omitempty"`
+	BindAddress string             `json:"bindAddress"`
+	Hostname    string             `json:"hostname,omitempty"`
+	ProxyURL    string             `json:"proxyURL,omitempty"`
+	Traffic     *TrafficStats      `json:"traffic,omitempty"`
+
+	AutoStopAt     time.Time `json:"autoStopAt"`
+	AutoStopReason string    `json:"autoStopReason,omitempty"`
+
+	InSchedule         bool      `json:"inSchedule"`
+	NextScheduleChange time.Time `json:"nextScheduleChange"`
+
+	ActiveEndpoint string `json:"activeEndpoint,omitempty"` // Destination in use when alternatives are configured
+	FailedOver     bool   `json:"failedOver,omitempty"`     // Not on the primary endpoint in priority mode
+
+	Master *MasterStatus `json:"master,omitempty"` // Shared connection of a multiplexed tunnel
 }
 
 // TunnelManager manages multiple SSH tunnels
@@ -60,18 +149,24 @@ type TunnelManager struct {
 	tunnels        map[string]*Tunnel
 	mutex          sync.RWMutex
 	configFile     string
+	settingsFile   string
+	settings       Settings
 	networkMonitor *NetworkMonitor
-	sseClients     map[chan string]bool
+	sseClients     map[chan string]*sseFilter
 	sseMutex       sync.RWMutex
+	transitions    chan TunnelTransition
+	statusChanged  chan struct{}
+	masters        map[string]*muxMaster // Shared ssh connections by host
+	mastersMutex   sync.Mutex
 }
 
-// AddSSEClient adds a new SSE client
-func (tm *TunnelManager) AddSSEClient() chan string {
+// AddSSEClient adds a new SSE client that receives events about the selected tunnels
+func (tm *TunnelManager) AddSSEClient(selector TunnelSelector) chan string {
 	tm.sseMutex.Lock()
 	defer tm.sseMutex.Unlock()
 
 	client := make(chan string, 10)
-	tm.sseClients[client] = true
+	tm.sseClients[client] = newSSEFilter(selector)
 	return client
 }
 
@@ -86,20 +181,24 @@ func (tm *TunnelManager) RemoveSSEClient(client chan string) {
 
 // BroadcastSSE sends an event to all SSE clients
 func (tm *TunnelManager) BroadcastSSE(eventType string, data interface{}) {
-	tm.sseMutex.RLock()
-	defer tm.sseMutex.RUnlock()
+	// Filters record what they see, so this takes the write lock
+	tm.sseMutex.Lock()
+	defer tm.sseMutex.Unlock()
 
-	eventData, _ := json.Marshal(map[string]interface{}{
-		"type":      eventType,
-		"data":      data,
-		"timestamp": time.Now().UTC(),
-	})
+	message := encodeSSE(eventType, data)
 
-	message := string(eventData)
+	for client, filter := range tm.sseClients {
+		clientMessage := message
+		if filter != nil {
+			filtered, ok := filter.apply(data)
+			if !ok {
+				continue
+			}
+			clientMessage = encodeSSE(eventType, filtered)
+		}
 
-	for client := range tm.sseClients {
 		select {
-		case client <- message:
+		case client <- clientMessage:
 		default:
 			// Client buffer is full, skip
 		}
@@ -110,13 +209,39 @@ func (tm *TunnelManager) BroadcastSSE(eventType string, data interface{}) {
 type Tunnel struct {
 	config          TunnelConfig
 	cmd             *exec.Cmd
-	status          string
+	status          TunnelState
+	stateReason     string
+	history         []TunnelTransition
 	lastError       string
 	connectedAt     time.Time
 	cancel          context.CancelFunc
 	mutex           sync.RWMutex
 	healthTicker    *time.Ticker
 	lastHealthCheck time.Time
+	healthResult    *HealthCheckResult
+	manager         *TunnelManager
+	attempt         int
+	nextRetryAt     time.Time
+	wake            chan string
+	restartReason   string
+	endpoint        *SSHEndpoint
+	boundPort       string // Port ssh actually binds when next-free-port moved it
+	listener        *managedListener
+	forwardPort     string // Internal port ssh forwards to when the listener is managed
+	traffic         trafficCounters
+	ready           chan struct{} // Closed while ssh's forward can take connections
+	activate        chan struct{} // Signals an idle on-demand tunnel that a client is waiting
+	startedAt       time.Time     // When the tunnel was last started, for maxLifetime
+	lastActivity    time.Time     // Last traffic seen, for idleTimeout
+	observedBytes   int64         // Relayed bytes at the last activity check
+
+	endpointIndex      int  // Active endpoint: 0 is the command's host, then config.Endpoints
+	endpointUsed       bool // A round-robin attempt was made since the last start
+	exhaustedEndpoints int  // Endpoints that used up their retry budget since the last connection
+
+	master *muxMaster // Shared connection carrying the forwards of a multiplexed tunnel
+
+	logs tunnelLog // Recent ssh output, lifecycle messages and health check results
 }
 
 // isPortAvailable checks if a port is available for binding
@@ -130,28 +255,6 @@ func isPortAvailable(port string) bool {
 	return true
 }
 
-// getProcessesUsingPort returns a list of PIDs using the specified port
-func getProcessesUsingPort(port string) ([]int, error) {
-	cmd := exec.Command("lsof", "-ti", fmt.Sprintf(":%s", port))
-	output, err := cmd.Output()
-	if err != nil {
-		// If lsof fails, the port might be free or lsof not available
-		return []int{}, nil
-	}
-
-	var pids []int
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	for _, line := range lines {
-		if line != "" {
-			if pid, err := strconv.Atoi(line); err == nil {
-				pids = append(pids, pid)
-			}
-		}
-	}
-
-	return pids, nil
-}
-
 // killProcessesOnPort kills all processes using the specified port
 func killProcessesOnPort(port string) error {
 	pids, err := getProcessesUsingPort(port)
@@ -200,34 +303,6 @@ func killProcessesOnPort(port string) error {
 	return nil
 }
 
-// ensurePortAvailable ensures the port is available, killing processes if necessary
-func ensurePortAvailable(port string) error {
-	if isPortAvailable(port) {
-		log.Printf("Port %s is already available", port)
-		return nil
-	}
-
-	log.Printf("Port %s is in use, attempting to free it", port)
-
-	// Check if we're running with sufficient privileges
-	if os.Geteuid() != 0 {
-		log.Printf("Warning: Not running as root - may not be able to kill all processes on port %s", port)
-		// Still try to kill processes, but warn user
-	}
-
-	return killProcessesOnPort(port)
-}
-
-// getProcessInfoForPort gets detailed information about processes using a port
-func getProcessInfoForPort(port string) string {
-	cmd := exec.Command("lsof", "-i", fmt.Sprintf(":%s", port))
-	output, err := cmd.Output()
-	if err != nil {
-		return fmt.Sprintf("Could not get process info for port %s", port)
-	}
-	return string(output)
-}
-
 // NewTunnelManager creates a new tunnel manager with network monitoring
 func NewTunnelManager() *TunnelManager {
 	// Determine config file location
@@ -240,12 +315,20 @@ func NewTunnelManager() *TunnelManager {
 	configDir := filepath.Join(homeDir, ".tunnel-manager")
 	os.MkdirAll(configDir, 0755)
 	configFile := filepath.Join(configDir, "tunnels.json")
+	settingsFile := filepath.Join(configDir, "settings.json")
+
+	settings := loadSettings(settingsFile)
 
 	tm := &TunnelManager{
 		tunnels:        make(map[string]*Tunnel),
 		configFile:     configFile,
-		networkMonitor: NewNetworkMonitor(),
-		sseClients:     make(map[chan string]bool),
+		settingsFile:   settingsFile,
+		settings:       settings,
+		networkMonitor: NewNetworkMonitor(NewNetworkProber(settings.Network)),
+		sseClients:     make(map[chan string]*sseFilter),
+		transitions:    make(chan TunnelTransition, 256),
+		statusChanged:  make(chan struct{}, 1),
+		masters:        make(map[string]*muxMaster),
 	}
 
 	// Set up SSE event sender for network monitor
@@ -253,13 +336,47 @@ func NewTunnelManager() *TunnelManager {
 
 	// Load existing configurations
 	tm.loadConfig()
+	tm.syncHostsFile()
 
 	// Start network monitoring
 	ctx := context.Background()
 	tm.networkMonitor.Start(ctx)
 
-	// Start background status broadcaster
-	go tm.startStatusBroadcaster(ctx)
+	// Answer <tunnel>.tunnel names locally
+	if settings.DNS.Enabled {
+		if err := tm.startDNS(ctx); err != nil {
+			log.Printf("Warning: DNS responder not started: %v", err)
+		}
+	}
+
+	// Serve http://<tunnel>.localhost for web dashboards
+	if settings.Proxy.Enabled {
+		if err := tm.startProxy(ctx); err != nil {
+			log.Printf("Warning: reverse proxy not started: %v", err)
+		}
+	}
+
+	// Start broadcasting tunnel transitions
+	go tm.runEventLoop(ctx)
+
+	// Restart tunnels when the machine resumes from suspend
+	go tm.watchClock(ctx)
+
+	// Disable tunnels that outlive their idle timeout, lifetime or expiry
+	go tm.watchLifetimes(ctx)
+
+	// Start and stop scheduled tunnels at their window boundaries
+	go tm.runScheduler(ctx)
+
+	// Check the shared connections of multiplexed tunnels
+	go tm.watchMasters(ctx)
+
+	// Interface, address or route changes (for example switching Wi-Fi networks)
+	// make waiting tunnels retry at once instead of sleeping out their backoff
+	tm.networkMonitor.AddChangeCallback(func(changes []NetworkChange) {
+		tm.enforcePreconditions()
+		tm.wakeWaitingTunnels(fmt.Sprintf("network changed: %s", changes[len(changes)-1]))
+	})
 
 	// Add network change callback to restart tunnels when network comes back
 	tm.networkMonitor.AddCallback(func(isConnected bool) {
@@ -274,11 +391,23 @@ func NewTunnelManager() *TunnelManager {
 	return tm
 }
 
+// newTunnel creates a tunnel owned by this manager
+func (tm *TunnelManager) newTunnel(config TunnelConfig) *Tunnel {
+	return &Tunnel{
+		config:   config,
+		status:   StateDisconnected,
+		manager:  tm,
+		wake:     make(chan string, 1),
+		ready:    make(chan struct{}),
+		activate: make(chan struct{}, 1),
+	}
+}
+
 func (tm *TunnelManager) AddTunnel(config TunnelConfig) error {
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
 
-	// Extract local port from command if not provided
+	// Extract local port and bind address from command if not provided
 	if config.LocalPort == "" {
 		port, err := extractLocalPort(config.Command)
 		if err != nil {
@@ -287,31 +416,78 @@ func (tm *TunnelManager) AddTunnel(config TunnelConfig) error {
 		config.LocalPort = port
 		config.AutoExtracted = true
 	}
-
-	// Check if port is available and free it if necessary
-	if !isPortAvailable(config.LocalPort) {
-		log.Printf("Port %s is in use. Process info:", config.LocalPort)
-		log.Printf("%s", getProcessInfoForPort(config.LocalPort))
-
-		if err := ensurePortAvailable(config.LocalPort); err != nil {
-			return fmt.Errorf("failed to free port %s: %v", config.LocalPort, err)
+	if config.BindAddress == "" && config.Allocate == "" {
+		if bind, _, err := extractLocalForward(config.Command); err == nil {
+			config.BindAddress = bind
 		}
+	}
+	if err := validateAllocation(config); err != nil {
+		return err
+	}
 
-		// Double-check that port is now available
-		if !isPortAvailable(config.LocalPort) {
-			return fmt.Errorf("port %s is still not available after cleanup attempt", config.LocalPort)
+	if err := validateHealthCheck(config.HealthCheck); err != nil {
+		return err
+	}
+	if config.Retry != nil {
+		if err := config.Retry.validate(); err != nil {
+			return fmt.Errorf("invalid retry policy: %v", err)
 		}
 	}
+	if err := validatePreconditions(config.Preconditions); err != nil {
+		return err
+	}
+	if err := validatePortPolicy(config); err != nil {
+		return err
+	}
+	if err := validateListener(config); err != nil {
+		return err
+	}
+	if err := validateOnDemand(config); err != nil {
+		return err
+	}
+	if err := validateLifetime(config); err != nil {
+		return err
+	}
+	if config.Schedule != nil {
+		if err := config.Schedule.validate(); err != nil {
+			return fmt.Errorf("invalid schedule: %v", err)
+		}
+	}
+	if err := tm.validateDependencies(config); err != nil {
+		return err
+	}
+	if err := validateTags(config.Tags); err != nil {
+		return err
+	}
+	if err := tm.validateHostname(config); err != nil {
+		return err
+	}
+	if err := validateEndpoints(config); err != nil {
+		return err
+	}
 
-	tunnel := &Tunnel{
-		config: config,
-		status: "disconnected",
+	// Give the tunnel its own loopback address or port, keeping an earlier allocation
+	var previous *TunnelConfig
+	if existing, exists := tm.tunnels[config.Name]; exists {
+		previous = &existing.config
+	}
+	if err := tm.allocateBinding(&config, previous); err != nil {
+		return err
 	}
 
+	// Re-adding an existing tunnel updates it in place
+	if existing, exists := tm.tunnels[config.Name]; exists {
+		return tm.updateTunnel(existing, config)
+	}
+
+	// Port conflicts are resolved by the tunnel's port policy when it connects
+	tunnel := tm.newTunnel(config)
+
 	tm.tunnels[config.Name] = tunnel
 
 	// Save configuration
 	tm.saveConfig()
+	tm.notifyStatusChanged()
 
 	if config.Enabled {
 		go tunnel.Start()
@@ -320,6 +496,35 @@ func (tm *TunnelManager) AddTunnel(config TunnelConfig) error {
 	return nil
 }
 
+// updateTunnel applies a new configuration to an existing tunnel. A running tunnel
+// reconnects straight away with the new settings instead of finishing its backoff.
+// The caller must hold tm.mutex.
+func (tm *TunnelManager) updateTunnel(tunnel *Tunnel, config TunnelConfig) error {
+	tunnel.mutex.Lock()
+	tunnel.config = config
+	running := tunnel.cancel != nil
+	// The endpoint list may have changed, so start over from the primary
+	tunnel.endpointIndex = 0
+	tunnel.exhaustedEndpoints = 0
+	tunnel.mutex.Unlock()
+
+	tm.saveConfig()
+	tm.notifyStatusChanged()
+
+	log.Printf("Updated configuration for tunnel '%s'", config.Name)
+
+	switch {
+	case config.Enabled && running:
+		go tunnel.Restart("configuration changed")
+	case config.Enabled:
+		go tunnel.Start()
+	case running:
+		tunnel.Stop()
+	}
+
+	return nil
+}
+
 // func (tm *TunnelManager) GetStatus() []TunnelStatus {
 // 	tm.mutex.RLock()
 // 	defer tm.mutex.RUnlock()
@@ -367,12 +572,16 @@ func (tm *TunnelManager) ToggleTunnel(name string) error {
 		return fmt.Errorf("tunnel not found: %s", name)
 	}
 
+	tunnel.mutex.Lock()
 	tunnel.config.Enabled = !tunnel.config.Enabled
+	enabled := tunnel.config.Enabled
+	tunnel.mutex.Unlock()
 
 	// Save configuration
 	tm.saveConfig()
+	tm.notifyStatusChanged()
 
-	if tunnel.config.Enabled {
+	if enabled {
 		go tunnel.Start()
 	} else {
 		tunnel.Stop()
@@ -381,6 +590,61 @@ func (tm *TunnelManager) ToggleTunnel(name string) error {
 	return nil
 }
 
+// setEnabled sets the tunnel's enabled flag and reports whether that changed it
+func (t *Tunnel) setEnabled(enabled bool) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.config.Enabled == enabled {
+		return false
+	}
+	t.config.Enabled = enabled
+	return true
+}
+
+// GetHistory returns the recorded state transitions of a tunnel
+func (tm *TunnelManager) GetHistory(name string) ([]TunnelTransition, error) {
+	tm.mutex.RLock()
+	tunnel, exists := tm.tunnels[name]
+	tm.mutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("tunnel not found: %s", name)
+	}
+	return tunnel.History(), nil
+}
+
+// ReconnectTunnel makes a tunnel reconnect now, skipping any pending backoff
+func (tm *TunnelManager) ReconnectTunnel(name string) error {
+	tm.mutex.RLock()
+	tunnel, exists := tm.tunnels[name]
+	tm.mutex.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("tunnel not found: %s", name)
+	}
+
+	tunnel.mutex.RLock()
+	enabled := tunnel.config.Enabled
+	running := tunnel.cancel != nil
+	tunnel.mutex.RUnlock()
+
+	if !enabled {
+		return fmt.Errorf("tunnel is disabled: %s", name)
+	}
+
+	log.Printf("Reconnect requested for tunnel '%s'", name)
+
+	// A failed tunnel has no maintenance loop left to wake up
+	if !running {
+		tunnel.Start()
+		return nil
+	}
+
+	tunnel.Restart("reconnect requested")
+	return nil
+}
+
 func (tm *TunnelManager) DeleteTunnel(name string) error {
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
@@ -390,11 +654,17 @@ func (tm *TunnelManager) DeleteTunnel(name string) error {
 		return fmt.Errorf("tunnel not found: %s", name)
 	}
 
+	// Deleting a dependency would leave its dependents waiting forever
+	if dependents := tm.dependentNames(name); len(dependents) > 0 {
+		return fmt.Errorf("tunnel %s is required by %s", name, strings.Join(dependents, ", "))
+	}
+
 	tunnel.Stop()
 	delete(tm.tunnels, name)
 
 	// Save configuration
 	tm.saveConfig()
+	tm.notifyStatusChanged()
 
 	return nil
 }
@@ -403,37 +673,63 @@ func (t *Tunnel) Start() {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	if t.status == "connected" || t.status == "connecting" {
-		log.Printf("Tunnel '%s' is already %s, skipping start", t.config.Name, t.status)
+	// A running maintenance loop owns the tunnel whatever state it is in,
+	// including mid-backoff, so only start one when none is active
+	if t.cancel != nil {
+		log.Printf("Tunnel '%s' is already running (%s), skipping start", t.config.Name, t.status)
 		return
 	}
 
-	// Cancel any existing maintenance goroutine
-	if t.cancel != nil {
-		t.cancel()
+	// Outside its schedule the tunnel waits for the scheduler to start it
+	if inSchedule, next := t.scheduleState(time.Now()); !inSchedule {
+		t.setState(StateDisconnected, scheduleReason(next))
+		log.Printf("Tunnel '%s' is outside its schedule, not starting", t.config.Name)
+		return
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	t.cancel = cancel
 
-	// Set status to connecting to prevent multiple starts
-	t.status = "connecting"
+	t.setState(StateConnecting, "start requested")
+	t.attempt = 0
+	t.nextRetryAt = time.Time{}
+	t.startedAt = time.Now()
+	t.lastActivity = t.startedAt
+
+	// Every endpoint gets a fresh retry budget; priority tunnels start from the primary
+	t.exhaustedEndpoints = 0
+	t.endpointUsed = false
+	if !t.roundRobin() {
+		t.endpointIndex = 0
+	}
 
 	log.Printf("Starting maintenance goroutine for tunnel '%s'", t.config.Name)
 
 	// Start health monitoring
 	t.startHealthMonitoring(ctx)
+	go t.watchFailback(ctx)
 
+	// On-demand tunnels only listen until a client connects
+	if t.isOnDemand() {
+		go t.standby(ctx)
+		return
+	}
 	go t.maintain(ctx)
 }
 
 // Stop stops the tunnel and cleans up resources
 func (t *Tunnel) Stop() {
+	t.StopWithReason("stopped")
+}
+
+// StopWithReason stops the tunnel, recording why in the transition history
+func (t *Tunnel) StopWithReason(reason string) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
 	if t.cancel != nil {
 		t.cancel()
+		t.cancel = nil
 	}
 
 	if t.cmd != nil && t.cmd.Process != nil {
@@ -445,23 +741,81 @@ func (t *Tunnel) Stop() {
 		t.healthTicker = nil
 	}
 
-	t.status = "disconnected"
+	t.closeListener()
+	t.forwardPort = ""
+
+	t.nextRetryAt = time.Time{}
+	t.setState(StateDisconnected, reason)
+}
+
+// Wake interrupts the maintenance loop's current wait so it retries immediately
+func (t *Tunnel) Wake(reason string) {
+	select {
+	case t.wake <- reason:
+	default:
+		// A wake-up is already pending
+	}
+}
+
+// Restart drops the current ssh session, if any, and reconnects without backoff
+func (t *Tunnel) Restart(reason string) {
+	t.mutex.Lock()
+	if t.cancel == nil {
+		t.mutex.Unlock()
+		return
+	}
+
+	if t.cmd != nil && t.cmd.Process != nil && t.cmd.ProcessState == nil {
+		log.Printf("Restarting ssh session for tunnel '%s': %s", t.config.Name, reason)
+		t.restartReason = reason
+		t.cmd.Process.Kill()
+	}
+	// A shared connection stays up for the other tunnels; only this forward is redone
+	if t.master != nil {
+		log.Printf("Restarting forward of tunnel '%s': %s", t.config.Name, reason)
+		t.restartReason = reason
+	}
+	t.mutex.Unlock()
+
+	t.Wake(reason)
+}
+
+// takeRestartReason returns and clears a pending restart request
+func (t *Tunnel) takeRestartReason() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	reason := t.restartReason
+	t.restartReason = ""
+	return reason
 }
 
 func (t *Tunnel) maintain(ctx context.Context) {
-	retryDelay := 5 * time.Second
-	maxRetryDelay := 60 * time.Second
 	networkCheckInterval := 5 * time.Second
 	wasNetworkDown := false
-
-	// Start health monitoring
-	t.startHealthMonitoring(ctx)
+	retry := newBackoff(t.retryPolicy())
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
+			// Dependents wait until every tunnel they depend on is connected
+			if !t.waitForDependencies(ctx) {
+				return
+			}
+
+			// Tunnels that depend on a VPN or similar wait here until it is up
+			if err := t.checkPreconditions(); err != nil {
+				log.Printf("Tunnel '%s' waiting for precondition: %v", t.config.Name, err)
+				if !t.waitForPreconditions(ctx, err) {
+					return
+				}
+				log.Printf("Preconditions met for tunnel '%s', connecting", t.config.Name)
+				t.resetRetry(retry)
+				continue
+			}
+
 			// Check network connectivity before attempting connection
 			networkAvailable := t.isNetworkAvailable()
 
@@ -469,8 +823,9 @@ func (t *Tunnel) maintain(ctx context.Context) {
 				if !wasNetworkDown {
 					// Network just went down
 					t.mutex.Lock()
-					t.status = "error"
-					t.lastError = "Network unavailable - waiting for connection"
+					if ctx.Err() == nil {
+						t.setError(StateError, "Network unavailable - waiting for connection")
+					}
 					t.mutex.Unlock()
 					log.Printf("Network became unavailable for tunnel '%s'", t.config.Name)
 					wasNetworkDown = true
@@ -480,75 +835,145 @@ func (t *Tunnel) maintain(ctx context.Context) {
 				t.waitForNetwork(ctx, networkCheckInterval)
 				continue
 			} else if wasNetworkDown {
-				// Network just came back
+				// Network just came back - outages don't count against the retry budget
 				log.Printf("Network restored for tunnel '%s', attempting reconnection", t.config.Name)
 				wasNetworkDown = false
-				retryDelay = 2 * time.Second // Quick retry when network comes back
+				t.resetRetry(retry)
 			}
 
-			// Check if SSH host is reachable
-			if !t.isSSHHostReachable() {
+			// Round-robin tunnels spread their attempts over every endpoint
+			t.nextEndpoint()
+
+			// Check if SSH host is reachable, failing over to an alternative endpoint if not
+			if !t.isSSHHostReachable() && !t.failover(ctx) {
+				suffix := t.endpointSuffix()
 				t.mutex.Lock()
-				t.status = "error"
-				t.lastError = "SSH host unreachable"
+				if ctx.Err() == nil {
+					t.setError(StateError, "SSH host unreachable"+suffix)
+				}
 				t.mutex.Unlock()
-				log.Printf("SSH host unreachable for tunnel '%s'", t.config.Name)
+				log.Printf("SSH host unreachable for tunnel '%s'%s", t.config.Name, suffix)
 
-				// Wait before retrying
-				select {
-				case <-ctx.Done():
+				if !t.waitForRetry(ctx, retry) {
 					return
-				case <-time.After(retryDelay):
-					// Increase retry delay, but cap it
-					retryDelay = retryDelay * 2
-					if retryDelay > maxRetryDelay {
-						retryDelay = maxRetryDelay
-					}
-					continue
 				}
+				continue
 			}
 
-			// Reset retry delay on successful connectivity checks
-			if !wasNetworkDown {
-				retryDelay = 5 * time.Second
+			// Attempt to connect; on success this blocks until the tunnel fails
+			success := t.connect(ctx)
+
+			// A requested restart reconnects at once with the current configuration
+			if reason := t.takeRestartReason(); reason != "" {
+				log.Printf("Tunnel '%s' reconnecting immediately: %s", t.config.Name, reason)
+				retry = newBackoff(t.retryPolicy())
+				t.resetRetry(retry)
+				continue
 			}
 
-			// Attempt to connect
-			success := t.connect()
+			// A connection that stayed up long enough earns a fresh retry budget
+			t.mutex.RLock()
+			connectedAt := t.connectedAt
+			t.mutex.RUnlock()
+			if success && !connectedAt.IsZero() && time.Since(connectedAt) >= time.Duration(retry.policy.ResetAfter) {
+				t.resetRetry(retry)
+			}
 
-			// If connection was successful, it will have blocked until the tunnel failed
-			// Always wait before retrying, regardless of success/failure
-			select {
-			case <-ctx.Done():
+			if !t.waitForRetry(ctx, retry) {
 				return
-			case <-time.After(retryDelay):
-				// Increase retry delay, but cap it
-				if !success {
-					retryDelay = retryDelay * 2
-					if retryDelay > maxRetryDelay {
-						retryDelay = maxRetryDelay
-					}
-				} else {
-					// On successful connection that then failed, use a moderate delay
-					retryDelay = 10 * time.Second // Wait 10 seconds after a connection that failed
-				}
 			}
 		}
 	}
 }
 
+// retryPolicy resolves the tunnel's retry policy against the global settings and defaults
+func (t *Tunnel) retryPolicy() RetryPolicy {
+	var policy RetryPolicy
+	if t.config.Retry != nil {
+		policy = *t.config.Retry
+	}
+	if t.manager != nil {
+		policy = policy.withDefaults(t.manager.settings.Retry)
+	}
+	return policy.withDefaults(defaultRetryPolicy())
+}
+
+// resetRetry clears the backoff and the attempt counter shown in the status
+func (t *Tunnel) resetRetry(retry *backoff) {
+	retry.reset()
+	t.mutex.Lock()
+	t.attempt = 0
+	t.mutex.Unlock()
+}
+
+// waitForRetry sleeps until the next attempt is due. It returns false when the loop
+// should exit, either because it was cancelled or because the attempts are exhausted.
+func (t *Tunnel) waitForRetry(ctx context.Context, retry *backoff) bool {
+	if retry.exhausted() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+
+		// A cancelled context means Stop already took over the tunnel
+		if ctx.Err() != nil {
+			return false
+		}
+
+		// Another endpoint gets its own retry budget before the tunnel gives up
+		if t.failoverExhausted(retry) {
+			return true
+		}
+
+		t.setError(StateFailed, fmt.Sprintf("Giving up after %d attempts: %s", retry.attempt, t.lastError))
+		t.nextRetryAt = time.Time{}
+
+		// Release the loop and stop the health monitor that shares its context
+		t.cancel()
+		t.cancel = nil
+		t.closeListener()
+
+		log.Printf("Tunnel '%s' failed permanently after %d attempts", t.config.Name, retry.attempt)
+		return false
+	}
+
+	delay := retry.next()
+
+	t.mutex.Lock()
+	t.attempt = retry.attempt
+	t.nextRetryAt = time.Now().Add(delay)
+	t.mutex.Unlock()
+
+	log.Printf("Tunnel '%s' retrying in %s (attempt %d)", t.config.Name, delay.Round(time.Millisecond), retry.attempt)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+	case reason := <-t.wake:
+		log.Printf("Tunnel '%s' backoff cut short: %s", t.config.Name, reason)
+	}
+
+	t.mutex.Lock()
+	t.nextRetryAt = time.Time{}
+	t.mutex.Unlock()
+	return true
+}
+
 // startHealthMonitoring starts monitoring the tunnel health
 func (t *Tunnel) startHealthMonitoring(ctx context.Context) {
-	t.healthTicker = time.NewTicker(30 * time.Second) // Check every 30 seconds
+	ticker := time.NewTicker(t.config.HealthCheck.interval())
+	t.healthTicker = ticker
 
 	go func() {
-		defer t.healthTicker.Stop()
+		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-t.healthTicker.C:
+			case <-ticker.C:
 				t.performHealthCheck()
 			}
 		}
@@ -558,40 +983,120 @@ func (t *Tunnel) startHealthMonitoring(ctx context.Context) {
 // performHealthCheck checks if the tunnel is still working
 func (t *Tunnel) performHealthCheck() {
 	t.mutex.Lock()
-	defer t.mutex.Unlock()
 
 	t.lastHealthCheck = time.Now()
 
 	// Only check if we think we're connected
-	if t.status != "connected" {
+	if t.status != StateConnected && t.status != StateUnhealthy && t.status != StateHijacked {
+		t.mutex.Unlock()
 		return
 	}
 
 	// Check if the process is still running
-	if t.cmd == nil || t.cmd.Process == nil {
-		t.status = "error"
-		t.lastError = "SSH process terminated unexpectedly"
-		log.Printf("Health check failed for tunnel '%s': process terminated", t.config.Name)
+	pid := t.sshPID()
+	if pid == 0 {
+		t.setError(StateError, "SSH process terminated unexpectedly")
+		t.logHealth(false, "process terminated")
+		t.mutex.Unlock()
+		return
+	}
+	host, port := t.forwardBinding()
+	t.mutex.Unlock()
+
+	// Dialing the port, scanning its owners and probing the network take seconds, so
+	// they run without the lock that status requests wait on
+	portOpen := isPortOpen(host, port)
+	var message string
+	var foreign []PortProcess
+	networkAvailable := true
+	if portOpen {
+		message, foreign = checkListenerOwner(host, port, pid)
+		if message == "" {
+			networkAvailable = t.isNetworkAvailable()
+		}
+	}
+
+	t.mutex.Lock()
+
+	// The tunnel may have stopped or reconnected while the probes were running
+	if (t.status != StateConnected && t.status != StateUnhealthy && t.status != StateHijacked) || t.sshPID() != pid {
+		t.mutex.Unlock()
+		return
+	}
+
+	// Check if the port is still being forwarded
+	if !portOpen {
+		t.setError(StateError, "Local port no longer accessible")
+		t.logHealth(false, "port not accessible")
+		t.mutex.Unlock()
+		return
+	}
+
+	// Check that the port is ours: another listener means traffic may go elsewhere
+	if message != "" {
+		if t.status != StateHijacked {
+			t.recordEvent("port-hijacked", message, foreign)
+		}
+		t.setError(StateHijacked, message)
+		t.logHealth(false, message)
+		t.mutex.Unlock()
+		return
+	}
+	if t.status == StateHijacked {
+		t.lastError = ""
+		t.setState(StateConnected, "port ownership restored")
+		log.Printf("Tunnel '%s' port ownership restored", t.config.Name)
+	}
+
+	// Check basic network connectivity
+	if !networkAvailable {
+		t.setError(StateError, "Network connectivity lost")
+		t.logHealth(false, "network unavailable")
+		t.mutex.Unlock()
 		return
 	}
 
-	// Check if the port is still being forwarded
-	if !t.isPortOpen() {
-		t.status = "error"
-		t.lastError = "Local port no longer accessible"
-		log.Printf("Health check failed for tunnel '%s': port not accessible", t.config.Name)
+	hc := t.config.HealthCheck
+	addr := t.forwardAddress()
+	t.mutex.Unlock()
+
+	// A lost VPN or route means the session is dead even if the port still answers
+	if err := t.checkPreconditions(); err != nil {
+		t.logHealth(false, err.Error())
+		go t.Restart("precondition lost: " + err.Error())
+		return
+	}
+
+	if hc == nil {
+		t.logHealth(true, "")
+		return
+	}
+
+	// Run the application-level probe without holding the lock
+	result := runHealthCheck(*hc, addr)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.healthResult = &result
+
+	// The tunnel may have gone away while the probe was running
+	if t.status != StateConnected && t.status != StateUnhealthy {
 		return
 	}
 
-	// Check basic network connectivity
-	if !t.isNetworkAvailable() {
-		t.status = "error"
-		t.lastError = "Network connectivity lost"
-		log.Printf("Health check failed for tunnel '%s': network unavailable", t.config.Name)
+	if result.Healthy {
+		if t.status == StateUnhealthy {
+			log.Printf("Tunnel '%s' %s health check recovered", t.config.Name, hc.Type)
+			t.lastError = ""
+			t.setState(StateConnected, hc.Type+" health check recovered")
+		}
+		t.logHealth(true, fmt.Sprintf("%s, %s", hc.Type, result.Latency))
 		return
 	}
 
-	log.Printf("Health check passed for tunnel '%s'", t.config.Name)
+	t.setError(StateUnhealthy, fmt.Sprintf("%s health check failed: %s", hc.Type, result.Message))
+	t.logHealth(false, t.lastError)
 }
 
 // waitForNetwork waits for network connectivity to be restored
@@ -604,9 +1109,11 @@ func (t *Tunnel) waitForNetwork(ctx context.Context, checkInterval time.Duration
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			if t.isNetworkAvailable() {
-				return
-			}
+		case <-t.wake:
+		}
+
+		if t.isNetworkAvailable() {
+			return
 		}
 	}
 }
@@ -810,60 +1317,36 @@ func (t *Tunnel) waitForNetwork(ctx context.Context, checkInterval time.Duration
 
 // isNetworkAvailable checks if network connectivity is available
 func (t *Tunnel) isNetworkAvailable() bool {
-	// Multiple connectivity checks for robustness
-	checks := []func() bool{
-		t.checkDNSConnectivity,
-		t.checkInternetConnectivity,
-		t.checkDefaultGateway,
-	}
-
-	// If any check passes, consider network available
-	for _, check := range checks {
-		if check() {
-			return true
-		}
+	if t.manager == nil {
+		return true
 	}
-
-	return false
+	return t.manager.networkMonitor.prober.Available()
 }
 
-// checkDNSConnectivity checks if DNS resolution is working
-func (t *Tunnel) checkDNSConnectivity() bool {
-	// Try to resolve a public DNS server
-	_, err := net.LookupHost("8.8.8.8")
-	return err == nil
-}
-
-// checkInternetConnectivity checks if we can connect to the internet
-func (t *Tunnel) checkInternetConnectivity() bool {
-	// Try to establish a quick connection to a reliable service
-	conn, err := net.DialTimeout("tcp", "8.8.8.8:53", 3*time.Second)
+// isSSHHostReachable checks if the SSH server the command connects to is reachable,
+// using the effective host and port after ssh_config resolution
+func (t *Tunnel) isSSHHostReachable() bool {
+	endpoint, err := t.resolveEndpoint()
 	if err != nil {
+		log.Printf("Tunnel '%s': cannot resolve SSH endpoint: %v", t.config.Name, err)
 		return false
 	}
-	conn.Close()
-	return true
-}
-
-// checkDefaultGateway checks if we can reach the default gateway
-func (t *Tunnel) checkDefaultGateway() bool {
-	// Try to ping the default gateway
-	// This is a basic check that works on most networks
-	cmd := exec.Command("ping", "-c", "1", "-W", "2000", "8.8.8.8")
-	err := cmd.Run()
-	return err == nil
+	return t.canReach(endpoint)
 }
 
-// isSSHHostReachable checks if the SSH host is reachable
-func (t *Tunnel) isSSHHostReachable() bool {
-	// Extract host from SSH command
-	host := t.extractSSHHost()
-	if host == "" {
+// canReach dials the ssh server of an endpoint, or its first jump host
+func (t *Tunnel) canReach(endpoint SSHEndpoint) bool {
+	address, err := endpoint.probeAddress()
+	if err != nil {
+		log.Printf("Tunnel '%s': cannot resolve jump host: %v", t.config.Name, err)
 		return false
 	}
+	if address == "" {
+		// Reached through a ProxyCommand; let ssh find out
+		return true
+	}
 
-	// Try to connect to SSH port
-	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, "22"), 5*time.Second)
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
 	if err != nil {
 		return false
 	}
@@ -872,48 +1355,10 @@ func (t *Tunnel) isSSHHostReachable() bool {
 	return true
 }
 
-// extractSSHHost extracts the SSH host from the command
-func (t *Tunnel) extractSSHHost() string {
-	args, err := parseSSHCommand(t.config.Command)
-	if err != nil {
-		return ""
-	}
-
-	// Look for the host (usually the last argument without flags)
-	for i := len(args) - 1; i >= 0; i-- {
-		arg := args[i]
-		if !strings.HasPrefix(arg, "-") && strings.Contains(arg, "@") {
-			// Format: user@host
-			parts := strings.Split(arg, "@")
-			if len(parts) == 2 {
-				return parts[1]
-			}
-		} else if !strings.HasPrefix(arg, "-") && i == len(args)-1 {
-			// Just hostname as last argument
-			return arg
-		}
-	}
-
-	return ""
-}
-
 // extractLocalPort extracts the local port from SSH command
 func extractLocalPort(command string) (string, error) {
-	// Look for -L flag followed by port forwarding specification
-	re := regexp.MustCompile(`-L\s+(\d+):`)
-	matches := re.FindStringSubmatch(command)
-	if len(matches) >= 2 {
-		return matches[1], nil
-	}
-
-	// Alternative format: -L port:host:port
-	re2 := regexp.MustCompile(`-L\s+(\d+):[\w\.-]+:\d+`)
-	matches2 := re2.FindStringSubmatch(command)
-	if len(matches2) >= 2 {
-		return matches2[1], nil
-	}
-
-	return "", fmt.Errorf("could not find local port in command")
+	_, port, err := extractLocalForward(command)
+	return port, err
 }
 
 // parseSSHCommand parses the SSH command string into command and arguments
@@ -966,6 +1411,9 @@ func (tm *TunnelManager) saveConfig() {
 	} else {
 		log.Printf("Configuration saved to %s", tm.configFile)
 	}
+
+	// Keep hosts file names in step with the tunnel set
+	tm.syncHostsFile()
 }
 
 // loadConfig loads tunnel configurations from disk
@@ -987,31 +1435,53 @@ func (tm *TunnelManager) loadConfig() {
 	log.Printf("Loading %d tunnel configurations from %s", len(configs), tm.configFile)
 
 	for _, config := range configs {
-		tunnel := &Tunnel{
-			config: config,
-			status: "disconnected",
-		}
-		tm.tunnels[config.Name] = tunnel
+		tm.tunnels[config.Name] = tm.newTunnel(config)
+	}
 
-		// Auto-start enabled tunnels
-		if config.Enabled {
+	// Auto-start enabled tunnels. They start concurrently and waitForDependencies holds
+	// dependents back until their dependencies connect.
+	for _, tunnel := range tm.startOrder() {
+		if tunnel.config.Enabled {
 			go tunnel.Start()
 		}
 	}
 }
 
+// NetworkChange describes a change to the host's interfaces, addresses or routes
+type NetworkChange struct {
+	Kind      string `json:"kind"`                // "link", "address" or "route"
+	Action    string `json:"action"`              // "up", "down", "added", "removed" or "changed"
+	Interface string `json:"interface,omitempty"` // Interface the change applies to
+	Detail    string `json:"detail,omitempty"`    // Address or route destination
+}
+
+// String formats the change for logs
+func (c NetworkChange) String() string {
+	text := c.Kind + " " + c.Action
+	if c.Detail != "" {
+		text += " " + c.Detail
+	}
+	if c.Interface != "" {
+		text += " on " + c.Interface
+	}
+	return text
+}
+
 // NetworkMonitor monitors network connectivity changes
 type NetworkMonitor struct {
-	callbacks   []func(bool)
-	mutex       sync.RWMutex
-	isRunning   bool
-	eventSender func(string, interface{})
+	callbacks       []func(bool)
+	changeCallbacks []func([]NetworkChange)
+	mutex           sync.RWMutex
+	isRunning       bool
+	eventSender     func(string, interface{})
+	prober          *NetworkProber
 }
 
 // NewNetworkMonitor creates a new network monitor
-func NewNetworkMonitor() *NetworkMonitor {
+func NewNetworkMonitor(prober *NetworkProber) *NetworkMonitor {
 	return &NetworkMonitor{
 		callbacks: make([]func(bool), 0),
+		prober:    prober,
 	}
 }
 
@@ -1029,6 +1499,13 @@ func (nm *NetworkMonitor) AddCallback(callback func(bool)) {
 	nm.callbacks = append(nm.callbacks, callback)
 }
 
+// AddChangeCallback adds a callback for link, address and route changes
+func (nm *NetworkMonitor) AddChangeCallback(callback func([]NetworkChange)) {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+	nm.changeCallbacks = append(nm.changeCallbacks, callback)
+}
+
 // Start starts monitoring network changes
 func (nm *NetworkMonitor) Start(ctx context.Context) {
 	nm.mutex.Lock()
@@ -1042,16 +1519,30 @@ func (nm *NetworkMonitor) Start(ctx context.Context) {
 	go nm.monitor(ctx)
 }
 
-// monitor runs the network monitoring loop
+// monitor runs the network monitoring loop. On Linux it reacts to netlink link,
+// address and route events and only polls as a safety net; elsewhere it polls.
 func (nm *NetworkMonitor) monitor(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	pollInterval := 5 * time.Second
+	changes := make(chan NetworkChange, 64)
+
+	if err := watchNetworkChanges(ctx, changes); err != nil {
+		log.Printf("Network change events unavailable (%v) - polling every %s", err, pollInterval)
+	} else {
+		log.Printf("Watching network changes via netlink")
+		// Upstream outages never show up as local route changes, so keep a slow poll
+		pollInterval = 30 * time.Second
+	}
 
-	var lastNetworkState bool
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
 
 	// Initial network state check
-	currentState := nm.checkNetworkConnectivity()
-	lastNetworkState = currentState
+	lastNetworkState := nm.checkNetworkConnectivity()
+
+	// Changes arrive in bursts (an interface coming up adds addresses and routes),
+	// so collect them briefly and re-probe once
+	var pending []NetworkChange
+	var settle <-chan time.Time
 
 	for {
 		select {
@@ -1060,36 +1551,67 @@ func (nm *NetworkMonitor) monitor(ctx context.Context) {
 			nm.isRunning = false
 			nm.mutex.Unlock()
 			return
+		case change := <-changes:
+			pending = append(pending, change)
+			if settle == nil {
+				settle = time.After(500 * time.Millisecond)
+			}
+			continue
+		case <-settle:
+			settle = nil
 		case <-ticker.C:
-			currentState := nm.checkNetworkConnectivity()
-			if currentState != lastNetworkState {
-				log.Printf("Network state changed: %t -> %t", lastNetworkState, currentState)
-				nm.notifyCallbacks(currentState)
-
-				// Send SSE event about network change
-				if nm.eventSender != nil {
-					nm.eventSender("network_change", map[string]interface{}{
-						"available": currentState,
-						"previous":  lastNetworkState,
-						"timestamp": time.Now().UTC(),
-					})
-				}
+		}
+
+		currentState := nm.checkNetworkConnectivity()
+		batch := pending
+		pending = nil
+
+		if currentState == lastNetworkState && len(batch) == 0 {
+			continue
+		}
 
-				lastNetworkState = currentState
+		if currentState != lastNetworkState {
+			log.Printf("Network state changed: %t -> %t", lastNetworkState, currentState)
+			nm.notifyCallbacks(currentState)
+		}
+		if len(batch) > 0 {
+			for _, change := range batch {
+				log.Printf("Network change: %s", change)
+			}
+			nm.notifyChangeCallbacks(batch)
+		}
+
+		// Send SSE event about network change
+		if nm.eventSender != nil {
+			event := map[string]interface{}{
+				"available": currentState,
+				"previous":  lastNetworkState,
+				"timestamp": time.Now().UTC(),
 			}
+			if len(batch) > 0 {
+				event["changes"] = batch
+				event["interface"] = batch[len(batch)-1].Interface
+			}
+			nm.eventSender("network_change", event)
 		}
+
+		lastNetworkState = currentState
 	}
 }
 
 // checkNetworkConnectivity checks if network is available
 func (nm *NetworkMonitor) checkNetworkConnectivity() bool {
-	// Try to connect to a reliable service
-	conn, err := net.DialTimeout("tcp", "8.8.8.8:53", 3*time.Second)
-	if err != nil {
-		return false
+	return nm.prober.Available()
+}
+
+// notifyChangeCallbacks notifies callbacks registered for interface, address and route changes
+func (nm *NetworkMonitor) notifyChangeCallbacks(changes []NetworkChange) {
+	nm.mutex.RLock()
+	defer nm.mutex.RUnlock()
+
+	for _, callback := range nm.changeCallbacks {
+		go callback(changes)
 	}
-	conn.Close()
-	return true
 }
 
 // notifyCallbacks notifies all registered callbacks of network changes
@@ -1104,18 +1626,24 @@ func (nm *NetworkMonitor) notifyCallbacks(isConnected bool) {
 
 // onNetworkRestored handles network restoration by triggering reconnections
 func (tm *TunnelManager) onNetworkRestored() {
+	tm.wakeWaitingTunnels("network restored")
+}
+
+// wakeWaitingTunnels cuts the backoff of every enabled tunnel that is waiting to reconnect
+func (tm *TunnelManager) wakeWaitingTunnels(reason string) {
 	tm.mutex.RLock()
 	defer tm.mutex.RUnlock()
 
 	for _, tunnel := range tm.tunnels {
-		if tunnel.config.Enabled {
-			tunnel.mutex.Lock()
-			if tunnel.status == "error" && strings.Contains(tunnel.lastError, "Network") {
-				log.Printf("Triggering reconnection for tunnel '%s' after network restoration", tunnel.config.Name)
-				tunnel.status = "disconnected"
-				tunnel.lastError = ""
-			}
-			tunnel.mutex.Unlock()
+		tunnel.mutex.RLock()
+		enabled := tunnel.config.Enabled
+		waiting := tunnel.status == StateError || tunnel.status == StateDisconnected || tunnel.status == StateWaiting
+		tunnel.mutex.RUnlock()
+
+		// Cut the backoff short so the tunnel reconnects right away
+		if enabled && waiting {
+			log.Printf("Triggering reconnection for tunnel '%s': %s", tunnel.config.Name, reason)
+			tunnel.Wake(reason)
 		}
 	}
 }
@@ -1155,12 +1683,19 @@ func main() {
 			fmt.Printf("Build Time: %s\n", BuildTime)
 			fmt.Printf("Commit: %s\n", CommitHash)
 			return
+		case "up", "down", "restart":
+			os.Exit(runBulkCommand(os.Args[1], os.Args[2:]))
 		case "--help", "-h", "help":
 			fmt.Printf("Easy SSH Tunnel Manager - Web-based SSH tunnel management\n\n")
-			fmt.Printf("Usage: %s [options]\n\n", os.Args[0])
+			fmt.Printf("Usage: %s [options]\n", os.Args[0])
+			fmt.Printf("       %s up|down|restart [-t tag] [-s status] [name...]\n\n", os.Args[0])
 			fmt.Printf("Options:\n")
 			fmt.Printf("  --version, -v    Show version information\n")
 			fmt.Printf("  --help, -h       Show this help message\n\n")
+			fmt.Printf("Commands (sent to the running manager):\n")
+			fmt.Printf("  up               Enable and start the selected tunnels\n")
+			fmt.Printf("  down             Stop and disable the selected tunnels\n")
+			fmt.Printf("  restart          Restart the selected enabled tunnels\n\n")
 			fmt.Printf("Environment Variables:\n")
 			fmt.Printf("  PORT             Web server port (default: 10000)\n\n")
 			fmt.Printf("Web Interface:\n")
@@ -1193,7 +1728,13 @@ func main() {
 			return
 		}
 
-		json.NewEncoder(w).Encode(manager.GetStatus())
+		selector, err := parseSelector(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(filterStatuses(manager.GetStatus(), selector))
 	})
 
 	http.HandleFunc("/api/add", func(w http.ResponseWriter, r *http.Request) {
@@ -1280,6 +1821,112 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 	})
 
+	// Bulk actions: /api/bulk/{start|stop|restart}?tag=db&status=error
+	http.HandleFunc("/api/bulk/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		selector, err := parseSelector(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		action := strings.TrimPrefix(r.URL.Path, "/api/bulk/")
+		tunnels, err := manager.BulkAction(action, selector)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"action":    action,
+			"tunnels":   tunnels,
+			"timestamp": time.Now().UTC(),
+		})
+	})
+
+	// Per-tunnel resources: /api/tunnels/{name}/{action}
+	http.HandleFunc("/api/tunnels/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/api/tunnels/")
+		slash := strings.LastIndex(path, "/")
+		if slash <= 0 {
+			http.Error(w, "Tunnel name and action required", http.StatusBadRequest)
+			return
+		}
+		name, action := path[:slash], path[slash+1:]
+
+		switch action {
+		case "history":
+			if r.Method != "GET" {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			history, err := manager.GetHistory(name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(history)
+		case "reconnect":
+			if r.Method != "POST" {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			if err := manager.ReconnectTunnel(name); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			w.WriteHeader(http.StatusAccepted)
+		case "logs":
+			if r.Method != "GET" {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			logs, err := manager.GetLogs(name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			lines, _ := strconv.Atoi(r.URL.Query().Get("lines"))
+			if follow := r.URL.Query().Get("follow"); follow == "1" || follow == "true" {
+				streamLogs(w, r, logs, lines)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(logs.tail(lines))
+		default:
+			http.Error(w, "Unknown action: "+action, http.StatusNotFound)
+		}
+	})
+
 	// Server-sent events for real-time updates
 	http.HandleFunc("/api/events", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/event-stream")
@@ -1292,18 +1939,20 @@ func main() {
 			return
 		}
 
+		selector, err := parseSelector(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		// Add client to SSE broadcast list
-		client := manager.AddSSEClient()
+		client := manager.AddSSEClient(selector)
 		defer manager.RemoveSSEClient(client)
 
 		// Send initial status
-		status := manager.GetStatus()
-		data, _ := json.Marshal(map[string]interface{}{
-			"type":      "status_update",
-			"data":      status,
-			"timestamp": time.Now().UTC(),
-		})
-		fmt.Fprintf(w, "data: %s\n\n", data)
+		if data, ok := manager.FilterSSE(client, "status_update", manager.GetStatus()); ok {
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
 		w.(http.Flusher).Flush()
 
 		// Listen for events and context cancellation
@@ -1347,6 +1996,20 @@ func main() {
 		json.NewEncoder(w).Encode(response)
 	})
 
+	// Network probe results, useful when diagnosing blocked probe targets
+	http.HandleFunc("/api/network", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		prober := manager.networkMonitor.prober
+		response := map[string]interface{}{
+			"skipGating": prober.skipGating,
+			"probes":     prober.Results(),
+			"timestamp":  time.Now().UTC(),
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+
 	// Manual network change trigger for testing
 	http.HandleFunc("/api/trigger-network-change", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -1410,8 +2073,8 @@ func main() {
 		log.Printf("Manual port kill requested for port %s", port)
 
 		// Get process info before killing
-		processInfo := getProcessInfoForPort(port)
-		log.Printf("Processes using port %s:\n%s", port, processInfo)
+		sockets, _ := findPortSockets(port)
+		log.Printf("Processes using port %s:\n%s", port, getProcessInfoForPort(port))
 
 		// Kill processes on the port
 		if err := killProcessesOnPort(port); err != nil {
@@ -1421,11 +2084,11 @@ func main() {
 		}
 
 		response := map[string]interface{}{
-			"success":     true,
-			"port":        port,
-			"message":     fmt.Sprintf("Successfully freed port %s", port),
-			"processInfo": processInfo,
-			"timestamp":   time.Now().UTC(),
+			"success":   true,
+			"port":      port,
+			"message":   fmt.Sprintf("Successfully freed port %s", port),
+			"processes": sockets,
+			"timestamp": time.Now().UTC(),
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -1455,18 +2118,26 @@ func main() {
 		}
 
 		available := isPortAvailable(port)
-		pids, _ := getProcessesUsingPort(port)
-		processInfo := ""
-		if !available {
-			processInfo = getProcessInfoForPort(port)
+		sockets, err := findPortSockets(port)
+
+		pids := []int{}
+		seen := make(map[int]bool)
+		for _, socket := range sockets {
+			if socket.PID != 0 && !seen[socket.PID] {
+				seen[socket.PID] = true
+				pids = append(pids, socket.PID)
+			}
 		}
 
 		response := map[string]interface{}{
-			"port":        port,
-			"available":   available,
-			"pids":        pids,
-			"processInfo": processInfo,
-			"timestamp":   time.Now().UTC(),
+			"port":      port,
+			"available": available,
+			"pids":      pids,
+			"sockets":   sockets,
+			"timestamp": time.Now().UTC(),
+		}
+		if err != nil {
+			response["error"] = err.Error()
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -1517,6 +2188,9 @@ func main() {
 		log.Printf("Server shutdown error: %v", err)
 	}
 
+	manager.clearHostsFile()
+	manager.closeMasters()
+
 	log.Println("✅ Server stopped")
 }
 
@@ -1704,69 +2378,31 @@ func ensureSSHAgentRunning() error {
 	return nil
 }
 
-// startStatusBroadcaster periodically broadcasts tunnel status updates
-func (tm *TunnelManager) startStatusBroadcaster(ctx context.Context) {
-	ticker := time.NewTicker(3 * time.Second)
-	defer ticker.Stop()
-
-	// Track meaningful status changes only
-	type StatusSnapshot struct {
-		Name   string
-		Status string
-		Error  string
-		PID    int
-	}
-
-	var lastSnapshots []StatusSnapshot
-
+// runEventLoop broadcasts every tunnel transition as it happens, followed by a
+// fresh status snapshot so clients never have to diff or poll for changes
+func (tm *TunnelManager) runEventLoop(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			status := tm.GetStatus()
-
-			// Create current snapshots (excluding dynamic fields like uptime)
-			currentSnapshots := make([]StatusSnapshot, len(status))
-			for i, s := range status {
-				currentSnapshots[i] = StatusSnapshot{
-					Name:   s.Config.Name,
-					Status: s.Status,
-					Error:  s.LastError,
-					PID:    s.PID,
-				}
-			}
-
-			// Only broadcast if meaningful status changed
-			hasChanged := false
-
-			// Check if number of tunnels changed
-			if len(currentSnapshots) != len(lastSnapshots) {
-				hasChanged = true
-			} else {
-				// Check if any tunnel status changed meaningfully
-				for i, current := range currentSnapshots {
-					if i >= len(lastSnapshots) {
-						hasChanged = true
-						break
-					}
+		case transition := <-tm.transitions:
+			tm.BroadcastSSE("tunnel_transition", transition)
+			tm.drainTransitions()
+		case <-tm.statusChanged:
+		}
 
-					last := lastSnapshots[i]
-					if current.Name != last.Name ||
-						current.Status != last.Status ||
-						current.Error != last.Error ||
-						current.PID != last.PID {
-						hasChanged = true
-						break
-					}
-				}
-			}
+		tm.BroadcastSSE("status_update", tm.GetStatus())
+	}
+}
 
-			if hasChanged {
-				log.Printf("Broadcasting status update - meaningful changes detected")
-				tm.BroadcastSSE("status_update", status)
-				lastSnapshots = currentSnapshots
-			}
+// drainTransitions broadcasts queued transitions so a burst results in one status update
+func (tm *TunnelManager) drainTransitions() {
+	for {
+		select {
+		case transition := <-tm.transitions:
+			tm.BroadcastSSE("tunnel_transition", transition)
+		default:
+			return
 		}
 	}
 }
@@ -1782,7 +2418,7 @@ func (tm *TunnelManager) GetStatus() []TunnelStatus {
 
 		// Only calculate uptime for truly connected tunnels
 		uptime := ""
-		if tunnel.status == "connected" && !tunnel.connectedAt.IsZero() {
+		if (tunnel.status == StateConnected || tunnel.status == StateUnhealthy) && !tunnel.connectedAt.IsZero() {
 			// Ensure we've been connected for at least 5 seconds before showing uptime
 			connectedDuration := time.Since(tunnel.connectedAt)
 			if connectedDuration >= 5*time.Second {
@@ -1790,24 +2426,52 @@ func (tm *TunnelManager) GetStatus() []TunnelStatus {
 			}
 		}
 
-		pid := 0
-		if tunnel.cmd != nil && tunnel.cmd.Process != nil {
-			pid = tunnel.cmd.Process.Pid
-		}
+		pid := tunnel.sshPID()
 
 		lastHealthCheck := ""
 		if !tunnel.lastHealthCheck.IsZero() {
 			lastHealthCheck = tunnel.lastHealthCheck.Format("15:04:05")
 		}
 
+		maxAttempts := tunnel.retryPolicy().MaxAttempts
+		if maxAttempts < 0 {
+			maxAttempts = 0
+		}
+
+		autoStopAt, autoStopReason := tunnel.autoStop()
+		inSchedule, nextScheduleChange := tunnel.scheduleState(time.Now())
+
 		status := TunnelStatus{
 			Config:          tunnel.config,
 			Status:          tunnel.status,
+			StateReason:     tunnel.stateReason,
 			LastError:       tunnel.lastError,
 			ConnectedAt:     tunnel.connectedAt,
 			Uptime:          uptime,
 			PID:             pid,
 			LastHealthCheck: lastHealthCheck,
+			HealthCheck:     tunnel.healthResult,
+			Attempt:         tunnel.attempt,
+			MaxAttempts:     maxAttempts,
+			NextRetryAt:     tunnel.nextRetryAt,
+			Endpoint:        tunnel.endpoint,
+			BoundPort:       tunnel.boundPort,
+			BindAddress:     tunnel.listenAddress(),
+			Hostname:        tm.tunnelHostname(tunnel),
+			ProxyURL:        tm.proxyURL(tunnel),
+			Traffic:         tunnel.trafficStats(),
+			AutoStopAt:      autoStopAt,
+			AutoStopReason:  autoStopReason,
+
+			InSchedule:         inSchedule,
+			NextScheduleChange: nextScheduleChange,
+		}
+		if tunnel.master != nil {
+			status.Master = tm.masterStatus(tunnel.master)
+		}
+		if len(tunnel.config.Endpoints) > 0 {
+			status.ActiveEndpoint = tunnel.endpointName(tunnel.endpointIndex)
+			status.FailedOver = tunnel.endpointIndex > 0 && !tunnel.roundRobin()
 		}
 		tunnel.mutex.RUnlock()
 		statuses = append(statuses, status)
@@ -1817,32 +2481,55 @@ func (tm *TunnelManager) GetStatus() []TunnelStatus {
 }
 
 // Enhanced connection logic to prevent false connected states
-func (t *Tunnel) connect() bool {
+func (t *Tunnel) connect(loopCtx context.Context) bool {
 	t.mutex.Lock()
 
-	// Ensure port is available before attempting connection
-	if !isPortAvailable(t.config.LocalPort) {
-		log.Printf("Port %s is in use before connecting tunnel '%s', attempting to free it", t.config.LocalPort, t.config.Name)
-		if err := ensurePortAvailable(t.config.LocalPort); err != nil {
-			t.status = "error"
-			t.lastError = fmt.Sprintf("Failed to free port %s: %v", t.config.LocalPort, err)
+	if loopCtx.Err() != nil {
+		t.mutex.Unlock()
+		return false
+	}
+
+	t.mutex.Unlock()
+
+	// A managed listener left open by the previous session already holds the port;
+	// otherwise resolve a taken local port according to the tunnel's port policy
+	port := t.reusableListener()
+	var err error
+	if port == "" {
+		port, err = t.reclaimPort(loopCtx)
+	}
+	t.mutex.Lock()
+	if err != nil {
+		if loopCtx.Err() == nil {
+			t.setError(StateError, fmt.Sprintf("Local port unavailable: %v", err))
+		}
+		t.mutex.Unlock()
+		return false
+	}
+	if port == t.config.LocalPort {
+		t.boundPort = ""
+	} else {
+		t.boundPort = port
+	}
+	if t.isManaged() && loopCtx.Err() == nil {
+		if err := t.prepareManaged(port); err != nil {
+			t.setError(StateError, fmt.Sprintf("Managed listener unavailable: %v", err))
 			t.mutex.Unlock()
 			return false
 		}
 	}
 
-	t.status = "connecting"
 	t.lastError = ""
+	t.setState(StateConnecting, "connection attempt")
 	t.mutex.Unlock()
 
-	log.Printf("Connecting tunnel '%s' on port %s", t.config.Name, t.config.LocalPort)
+	log.Printf("Connecting tunnel '%s' on port %s", t.config.Name, port)
 
 	// Build SSH command with better options for tunneling
-	args, err := parseSSHCommand(t.config.Command)
+	args, err := t.activeArgs()
 	if err != nil {
 		t.mutex.Lock()
-		t.status = "error"
-		t.lastError = fmt.Sprintf("Failed to parse command: %v", err)
+		t.setError(StateError, fmt.Sprintf("Failed to parse command: %v", err))
 		t.mutex.Unlock()
 		return false
 	}
@@ -1850,8 +2537,7 @@ func (t *Tunnel) connect() bool {
 	// Ensure the first argument is actually 'ssh'
 	if len(args) == 0 || !strings.Contains(args[0], "ssh") {
 		t.mutex.Lock()
-		t.status = "error"
-		t.lastError = "Command must start with 'ssh'"
+		t.setError(StateError, "Command must start with 'ssh'")
 		t.mutex.Unlock()
 		return false
 	}
@@ -1883,23 +2569,37 @@ func (t *Tunnel) connect() bool {
 	if !strings.Contains(cmdStr, "UserKnownHostsFile") {
 		enhancedArgs = append(enhancedArgs, "-o", "UserKnownHostsFile=/dev/null")
 	}
-	if !strings.Contains(cmdStr, "LogLevel") {
+	if t.debug() {
+		enhancedArgs = append(enhancedArgs, "-v") // Verbose output goes to the tunnel log
+	} else if !strings.Contains(cmdStr, "LogLevel") {
 		enhancedArgs = append(enhancedArgs, "-o", "LogLevel=ERROR") // Reduce verbosity
 	}
 
+	// Forward from the address and port we actually bind
+	t.mutex.RLock()
+	forwardHost, forwardPort := t.forwardBinding()
+	t.mutex.RUnlock()
+	args = t.forwardArgs(args, forwardHost, forwardPort)
+
 	// Add the rest of the original arguments (skip the first 'ssh' argument)
 	if len(args) > 1 {
 		enhancedArgs = append(enhancedArgs, args[1:]...)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// Multiplexed tunnels add their forwards to the shared connection for the host
+	if t.config.Multiplex && t.manager != nil {
+		return t.connectShared(loopCtx, enhancedArgs, port)
+	}
+
+	// Tie ssh to the maintenance loop so stopping the tunnel kills it
+	ctx, cancel := context.WithCancel(loopCtx)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, enhancedArgs[0], enhancedArgs[1:]...)
 
 	// Capture stderr to see SSH errors
-	var stderr strings.Builder
-	cmd.Stderr = &stderr
+	stderr := t.newSSHOutput()
+	cmd.Stderr = stderr
 	cmd.Stdout = nil
 
 	t.mutex.Lock()
@@ -1912,29 +2612,42 @@ func (t *Tunnel) connect() bool {
 	err = cmd.Start()
 	if err != nil {
 		t.mutex.Lock()
-		t.status = "error"
-		t.lastError = fmt.Sprintf("Failed to start SSH: %v", err)
+		t.setError(StateError, fmt.Sprintf("Failed to start SSH: %v", err))
 		t.mutex.Unlock()
 		return false
 	}
 
+	// Reap the process in the background so startup notices if ssh dies early
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
 	// Wait longer and check more thoroughly for tunnel establishment
 	connected := false
+	processExited := false
+	hijacked := ""
+	var hijackers []PortProcess
 	maxAttempts := 15 // Give up to 15 seconds
 
-	for i := 0; i < maxAttempts; i++ {
-		time.Sleep(1 * time.Second)
-
-		// Check if process is still running first
-		if cmd.Process == nil {
+	for i := 0; i < maxAttempts && !processExited; i++ {
+		select {
+		case err = <-exited:
+			processExited = true
 			log.Printf("Tunnel '%s' process died during startup", t.config.Name)
-			break
+			continue
+		case <-time.After(1 * time.Second):
 		}
 
 		// Then check if port is accessible
-		if t.isPortOpen() {
+		if isPortOpen(forwardHost, forwardPort) {
 			// Double-check by trying to connect
-			if t.verifyPortConnection() {
+			if verifyPortConnection(forwardHost, forwardPort) {
+				// Something answers, but it must be ssh and not a process that grabbed the port
+				if hijacked, hijackers = checkListenerOwner(forwardHost, forwardPort, cmd.Process.Pid); hijacked != "" {
+					log.Printf("Tunnel '%s' port verification failed: %s", t.config.Name, hijacked)
+					break
+				}
 				connected = true
 				log.Printf("Tunnel '%s' port verification successful after %d seconds", t.config.Name, i+1)
 				break
@@ -1949,62 +2662,87 @@ func (t *Tunnel) connect() bool {
 
 	if connected {
 		t.mutex.Lock()
-		t.status = "connected"
 		t.connectedAt = time.Now()
 		t.lastError = ""
+		t.exhaustedEndpoints = 0
+		t.setState(StateConnected, "local port verified")
 		t.mutex.Unlock()
 
-		log.Printf("Tunnel '%s' connected successfully on port %s", t.config.Name, t.config.LocalPort)
+		// Wake-ups that arrived while connecting are stale now
+		select {
+		case <-t.wake:
+		default:
+		}
+
+		log.Printf("Tunnel '%s' connected successfully on port %s", t.config.Name, port)
+
+		// Probe the target right away instead of waiting for the first tick
+		if t.config.HealthCheck != nil {
+			go t.performHealthCheck()
+		}
 
 		// Wait for the command to finish
-		err = cmd.Wait()
+		err = <-exited
 
 		t.mutex.Lock()
-		if err != nil {
+		if loopCtx.Err() != nil {
+			// Stopped on purpose; Stop has already recorded the transition
+			log.Printf("Tunnel '%s' ssh session ended after stop", t.config.Name)
+		} else if t.restartReason != "" {
+			t.lastError = ""
+			t.setState(StateConnecting, t.restartReason)
+		} else if err != nil {
 			stderrOutput := stderr.String()
 			if stderrOutput != "" {
-				t.lastError = fmt.Sprintf("SSH tunnel failed: %v - %s", err, stderrOutput)
+				t.setError(StateError, fmt.Sprintf("SSH tunnel failed: %v - %s", err, stderrOutput))
 				log.Printf("Tunnel '%s' SSH stderr: %s", t.config.Name, stderrOutput)
 			} else {
-				t.lastError = fmt.Sprintf("SSH tunnel failed: %v", err)
+				t.setError(StateError, fmt.Sprintf("SSH tunnel failed: %v", err))
 			}
-			t.status = "error"
 			log.Printf("Tunnel '%s' exited with error: %v", t.config.Name, err)
 		} else {
-			t.status = "disconnected"
 			t.lastError = ""
+			t.setState(StateDisconnected, "ssh exited normally")
 			log.Printf("Tunnel '%s' exited normally", t.config.Name)
 		}
 		t.mutex.Unlock()
 		return true // Connection was established (even if it later failed)
 	} else {
-		// Connection failed to establish
-		t.mutex.Lock()
-
 		// Kill the process since it didn't establish properly
-		if cmd.Process != nil {
+		if !processExited {
 			cmd.Process.Kill()
+			<-exited
 		}
 
+		// Connection failed to establish
+		t.mutex.Lock()
+
 		stderrOutput := stderr.String()
-		if stderrOutput != "" {
-			t.lastError = fmt.Sprintf("Connection failed to establish: %s", stderrOutput)
+		if loopCtx.Err() != nil {
+			log.Printf("Tunnel '%s' connection attempt abandoned after stop", t.config.Name)
+		} else if t.restartReason != "" {
+			t.lastError = ""
+			t.setState(StateConnecting, t.restartReason)
+		} else if hijacked != "" {
+			t.recordEvent("port-hijacked", hijacked, hijackers)
+			t.setError(StateHijacked, hijacked)
+		} else if stderrOutput != "" {
+			t.setError(StateError, fmt.Sprintf("Connection failed to establish: %s", stderrOutput))
 			log.Printf("Tunnel '%s' failed to establish - stderr: %s", t.config.Name, stderrOutput)
 		} else {
-			t.lastError = "Connection failed to establish within timeout"
+			t.setError(StateError, "Connection failed to establish within timeout")
 			log.Printf("Tunnel '%s' failed to establish within %d seconds", t.config.Name, maxAttempts)
 		}
 
-		t.status = "error"
 		t.mutex.Unlock()
 		return false
 	}
 }
 
 // Add a more thorough port verification method
-func (t *Tunnel) verifyPortConnection() bool {
+func verifyPortConnection(host, port string) bool {
 	// Try to actually connect and send/receive data
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%s", t.config.LocalPort), 2*time.Second)
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(dialHost(host), port), 2*time.Second)
 	if err != nil {
 		return false
 	}
@@ -2018,18 +2756,18 @@ func (t *Tunnel) verifyPortConnection() bool {
 	return true
 }
 
-func (t *Tunnel) isPortOpen() bool {
+// isPortOpen reports whether something listens on ssh's forward, as returned by
+// forwardBinding
+func isPortOpen(host, port string) bool {
 	// Try to connect to the local port
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%s", t.config.LocalPort), 2*time.Second)
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(dialHost(host), port), 2*time.Second)
 	if err != nil {
 		// If connection failed, try alternative checks
 		// Check if something is listening on the port
-		ln, err := net.Listen("tcp", fmt.Sprintf(":%s", t.config.LocalPort))
-		if err != nil {
+		if !isBindAvailable(host, port) {
 			// Port is in use (which is good - means SSH is using it)
 			return true
 		}
-		ln.Close()
 		// Port is available (which is bad - means SSH isn't using it)
 		return false
 	}
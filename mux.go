@@ -0,0 +1,563 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Shared master connection timings
+const (
+	masterStartTimeout  = 20 * time.Second // How long a new master may take to authenticate
+	masterCheckInterval = 15 * time.Second // How often "ssh -O check" runs against each master
+	muxCommandTimeout   = 5 * time.Second  // Bound for a single "ssh -O" control command
+)
+
+// MasterStatus describes the shared ssh connection a multiplexed tunnel runs over
+type MasterStatus struct {
+	Host        string    `json:"host"`
+	ControlPath string    `json:"controlPath"`
+	PID         int       `json:"pid"`
+	Tunnels     []string  `json:"tunnels"`
+	LastCheck   time.Time `json:"lastCheck"`
+	CheckError  string    `json:"checkError,omitempty"`
+}
+
+// muxMaster is one ssh ControlMaster connection whose forwards are added and removed
+// with "ssh -O forward" and "ssh -O cancel" on behalf of the tunnels using it
+type muxMaster struct {
+	key         string
+	destination string
+	controlPath string
+	cmd         *exec.Cmd
+	stderr      *sshOutput
+
+	ready chan struct{} // Closed once the master accepts control commands
+	done  chan struct{} // Closed when the master process has exited
+	err   error         // Why the master exited, set before done is closed
+
+	users map[*Tunnel]bool // Guarded by TunnelManager.mastersMutex
+
+	mutex      sync.Mutex
+	killReason string
+	checkedAt  time.Time
+	checkError string
+}
+
+// splitForwards separates the -L, -R and -D forwards of an ssh command from the
+// options the master connection is opened with. Grouped flags such as "-NL 80:..."
+// keep their other letters.
+func splitForwards(args []string) (master, forwards []string, err error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("empty command")
+	}
+	master = []string{args[0]}
+
+next:
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" && i+1 < len(args) {
+			return append(master, args[i:i+2]...), forwards, nil
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return append(master, arg), forwards, nil
+		}
+
+		start := i
+		for j := 1; j < len(arg); j++ {
+			flag := arg[j]
+			if !strings.ContainsRune(sshFlagsWithValue, rune(flag)) {
+				continue
+			}
+
+			value := arg[j+1:]
+			if value == "" {
+				if i+1 >= len(args) {
+					return nil, nil, fmt.Errorf("option -%c requires an argument", flag)
+				}
+				i++
+				value = args[i]
+			}
+			if flag == 'L' || flag == 'R' || flag == 'D' {
+				forwards = append(forwards, "-"+string(flag), value)
+				if j > 1 {
+					master = append(master, arg[:j])
+				}
+				continue next
+			}
+			break
+		}
+		master = append(master, args[start:i+1]...)
+	}
+	return nil, nil, fmt.Errorf("no destination host in ssh command")
+}
+
+// masterKey identifies the ssh server a master connects to and the options it is
+// opened with, so only tunnels to the same host with the same identity, user and
+// config share it
+func masterKey(endpoint SSHEndpoint, masterArgs []string) string {
+	return strings.Join([]string{endpoint.String(), endpoint.ProxyJump, endpoint.ProxyCommand, strings.Join(masterArgs[1:], "\x00")}, "|")
+}
+
+// controlPath returns a short per-user socket path for a master; socket paths are
+// limited to about 100 bytes
+func controlPath(key string) (string, error) {
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("easytunnel-%d", os.Getuid()))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".sock"), nil
+}
+
+// control runs "ssh -O <operation>" against the master
+func (m *muxMaster) control(operation string, args ...string) error {
+	return m.controlWithOutput(nil, operation, args...)
+}
+
+// controlWithOutput runs "ssh -O <operation>" and, when output is set, passes what
+// ssh printed to it, e.g. -v debug lines for the tunnel log
+func (m *muxMaster) controlWithOutput(output *sshOutput, operation string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), muxCommandTimeout)
+	defer cancel()
+
+	command := append([]string{"-S", m.controlPath, "-O", operation}, args...)
+	command = append(command, m.destination)
+	printed, err := exec.CommandContext(ctx, "ssh", command...).CombinedOutput()
+
+	message := strings.TrimSpace(string(printed))
+	if output != nil {
+		output.Write(printed)
+		message = output.String() // Without the debug lines
+	}
+	if err != nil {
+		if message != "" {
+			return fmt.Errorf("%v: %s", err, message)
+		}
+		return err
+	}
+	return nil
+}
+
+// pid returns the master's process ID
+func (m *muxMaster) pid() int {
+	if m.cmd == nil || m.cmd.Process == nil {
+		return 0
+	}
+	return m.cmd.Process.Pid
+}
+
+// alive reports whether the master process is still running
+func (m *muxMaster) alive() bool {
+	select {
+	case <-m.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// kill ends the master, recording why for the tunnels that used it
+func (m *muxMaster) kill(reason string) {
+	m.mutex.Lock()
+	if m.killReason == "" {
+		m.killReason = reason
+	}
+	m.mutex.Unlock()
+
+	if m.cmd != nil && m.cmd.Process != nil {
+		m.cmd.Process.Kill()
+	}
+}
+
+// start launches the master and waits in the background until "ssh -O check"
+// answers, or kills it after masterStartTimeout
+func (m *muxMaster) start(args []string) error {
+	command := []string{"-M", "-S", m.controlPath, "-o", "ControlPersist=no"}
+	command = append(command, args[1:]...)
+
+	m.cmd = exec.Command("ssh", command...)
+	m.cmd.Stderr = m.stderr
+	if err := m.cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("Started shared ssh connection to %s (pid %d)", m.destination, m.pid())
+
+	go func() {
+		err := m.cmd.Wait()
+
+		m.mutex.Lock()
+		switch {
+		case m.killReason != "":
+			m.err = errors.New(m.killReason)
+		case m.stderr.String() != "":
+			m.err = fmt.Errorf("%v - %s", err, m.stderr.String())
+		case err != nil:
+			m.err = err
+		default:
+			m.err = errors.New("ssh exited")
+		}
+		m.mutex.Unlock()
+
+		os.Remove(m.controlPath)
+		close(m.done)
+		log.Printf("Shared ssh connection to %s closed: %v", m.destination, m.err)
+	}()
+
+	go func() {
+		deadline := time.Now().Add(masterStartTimeout)
+		for m.alive() {
+			if m.control("check") == nil {
+				m.mutex.Lock()
+				m.checkedAt = time.Now()
+				m.mutex.Unlock()
+				close(m.ready)
+				return
+			}
+			if time.Now().After(deadline) {
+				m.kill(fmt.Sprintf("not ready within %s", masterStartTimeout))
+				return
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+	}()
+	return nil
+}
+
+// acquireMaster returns the running master for key, starting one with args if there
+// is none, and registers the tunnel as a user. It waits until the master is ready.
+func (tm *TunnelManager) acquireMaster(ctx context.Context, t *Tunnel, key string, args []string) (*muxMaster, error) {
+	tm.mastersMutex.Lock()
+	m := tm.masters[key]
+	if m == nil || !m.alive() {
+		path, err := controlPath(key)
+		if err != nil {
+			tm.mastersMutex.Unlock()
+			return nil, err
+		}
+		m = &muxMaster{
+			key:         key,
+			destination: args[len(args)-1],
+			controlPath: path,
+			ready:       make(chan struct{}),
+			done:        make(chan struct{}),
+			users:       make(map[*Tunnel]bool),
+		}
+		m.stderr = &sshOutput{emit: func(line string) {
+			tm.logToUsers(m, line)
+		}}
+		os.Remove(path) // A socket left by an earlier run would stop the master from listening
+		if err := m.start(args); err != nil {
+			tm.mastersMutex.Unlock()
+			return nil, err
+		}
+		tm.masters[key] = m
+	}
+	m.users[t] = true
+	tm.mastersMutex.Unlock()
+
+	select {
+	case <-m.ready:
+		return m, nil
+	case <-m.done:
+		tm.releaseMaster(m, t)
+		return nil, m.err
+	case <-ctx.Done():
+		tm.releaseMaster(m, t)
+		return nil, ctx.Err()
+	}
+}
+
+// releaseMaster unregisters a tunnel and closes the master once nothing uses it
+func (tm *TunnelManager) releaseMaster(m *muxMaster, t *Tunnel) {
+	tm.mastersMutex.Lock()
+	delete(m.users, t)
+	unused := len(m.users) == 0
+	if unused && tm.masters[m.key] == m {
+		delete(tm.masters, m.key)
+	}
+	tm.mastersMutex.Unlock()
+
+	if unused && m.alive() {
+		if err := m.control("exit"); err != nil {
+			m.kill("no tunnels left")
+		}
+	}
+}
+
+// logToUsers adds a line of the master's ssh output to the log of every tunnel using it
+func (tm *TunnelManager) logToUsers(m *muxMaster, line string) {
+	tm.mastersMutex.Lock()
+	defer tm.mastersMutex.Unlock()
+
+	for user := range m.users {
+		user.logs.add(LogSSH, line)
+	}
+}
+
+// closeMasters ends every shared connection, for shutdown
+func (tm *TunnelManager) closeMasters() {
+	tm.mastersMutex.Lock()
+	masters := tm.masters
+	tm.masters = make(map[string]*muxMaster)
+	tm.mastersMutex.Unlock()
+
+	for _, m := range masters {
+		if m.control("exit") != nil {
+			m.kill("shutting down")
+		}
+	}
+}
+
+// watchMasters runs "ssh -O check" against every ready master. A master that fails
+// the check is closed, which drops every tunnel sharing it into a reconnect.
+func (tm *TunnelManager) watchMasters(ctx context.Context) {
+	ticker := time.NewTicker(masterCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		tm.mastersMutex.Lock()
+		masters := make([]*muxMaster, 0, len(tm.masters))
+		for _, m := range tm.masters {
+			masters = append(masters, m)
+		}
+		tm.mastersMutex.Unlock()
+
+		for _, m := range masters {
+			select {
+			case <-m.ready:
+			default:
+				continue // Still starting up
+			}
+			if !m.alive() {
+				continue
+			}
+
+			err := m.control("check")
+			m.mutex.Lock()
+			m.checkedAt = time.Now()
+			m.checkError = ""
+			if err != nil {
+				m.checkError = err.Error()
+			}
+			m.mutex.Unlock()
+
+			if err != nil {
+				log.Printf("Shared ssh connection to %s failed its check: %v", m.destination, err)
+				m.kill("ssh -O check failed: " + err.Error())
+			}
+		}
+		tm.notifyStatusChanged()
+	}
+}
+
+// masterStatus describes a master for the status of the tunnels using it
+func (tm *TunnelManager) masterStatus(m *muxMaster) *MasterStatus {
+	tm.mastersMutex.Lock()
+	var tunnels []string
+	for user := range m.users {
+		tunnels = append(tunnels, user.config.Name)
+	}
+	tm.mastersMutex.Unlock()
+	sort.Strings(tunnels)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return &MasterStatus{
+		Host:        m.destination,
+		ControlPath: m.controlPath,
+		PID:         m.pid(),
+		Tunnels:     tunnels,
+		LastCheck:   m.checkedAt,
+		CheckError:  m.checkError,
+	}
+}
+
+// sshPID returns the ssh process carrying the tunnel's forward: its own ssh or the
+// shared master. The caller must hold t.mutex.
+func (t *Tunnel) sshPID() int {
+	if t.master != nil {
+		return t.master.pid()
+	}
+	if t.cmd != nil && t.cmd.Process != nil {
+		return t.cmd.Process.Pid
+	}
+	return 0
+}
+
+// connectShared runs the tunnel's forwards over the shared master for its host
+// instead of a dedicated ssh process. It blocks while the forward is up and, like
+// connect, returns whether it was established.
+func (t *Tunnel) connectShared(ctx context.Context, args []string, port string) bool {
+	masterArgs, forwards, err := splitForwards(args)
+	if err != nil {
+		t.mutex.Lock()
+		t.setError(StateError, fmt.Sprintf("Failed to parse command: %v", err))
+		t.mutex.Unlock()
+		return false
+	}
+
+	t.mutex.RLock()
+	endpoint := t.endpoint
+	t.mutex.RUnlock()
+	if endpoint == nil {
+		resolved, err := resolveEndpointArgs(args)
+		if err != nil {
+			t.mutex.Lock()
+			t.setError(StateError, fmt.Sprintf("Cannot resolve SSH endpoint: %v", err))
+			t.mutex.Unlock()
+			return false
+		}
+		endpoint = &resolved
+	}
+
+	master, err := t.manager.acquireMaster(ctx, t, masterKey(*endpoint, masterArgs), masterArgs)
+	if err != nil {
+		t.mutex.Lock()
+		if ctx.Err() == nil {
+			t.setError(StateError, fmt.Sprintf("Shared connection failed: %v", err))
+		}
+		t.mutex.Unlock()
+		return false
+	}
+	defer t.manager.releaseMaster(master, t)
+
+	t.mutex.Lock()
+	t.master = master
+	t.mutex.Unlock()
+	defer func() {
+		t.mutex.Lock()
+		t.master = nil
+		t.mutex.Unlock()
+	}()
+
+	log.Printf("Adding forwards of tunnel '%s' to the shared connection to %s", t.config.Name, master.destination)
+	t.mutex.RLock()
+	debug := t.debug()
+	t.mutex.RUnlock()
+
+	// The master was started by whichever tunnel came first, so ask for -v here too
+	forwardArgs := forwards
+	var output *sshOutput
+	if debug {
+		forwardArgs = append([]string{"-v"}, forwards...)
+		output = t.newSSHOutput()
+	}
+	if err := master.controlWithOutput(output, "forward", forwardArgs...); err != nil {
+		t.mutex.Lock()
+		if ctx.Err() == nil {
+			t.setError(StateError, fmt.Sprintf("Port forward failed: %v", err))
+		}
+		t.mutex.Unlock()
+		return false
+	}
+	defer func() {
+		if master.alive() {
+			if err := master.control("cancel", forwards...); err != nil {
+				log.Printf("Tunnel '%s': cancelling forward failed: %v", t.config.Name, err)
+			}
+		}
+	}()
+
+	// The master owns the port, so verify it like a dedicated ssh process, probing
+	// without the lock
+	t.mutex.RLock()
+	host, forwardPort := t.forwardBinding()
+	t.mutex.RUnlock()
+	hijacked := ""
+	var hijackers []PortProcess
+	connected := false
+	for i := 0; i < 5 && !connected && hijacked == ""; i++ {
+		if isPortOpen(host, forwardPort) && verifyPortConnection(host, forwardPort) {
+			if hijacked, hijackers = checkListenerOwner(host, forwardPort, master.pid()); hijacked == "" {
+				connected = true
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-master.done:
+		case <-time.After(time.Second):
+		}
+	}
+
+	t.mutex.Lock()
+	if !connected {
+		switch {
+		case ctx.Err() != nil:
+		case hijacked != "":
+			t.recordEvent("port-hijacked", hijacked, hijackers)
+			t.setError(StateHijacked, hijacked)
+		case !master.alive():
+			t.setError(StateError, fmt.Sprintf("Shared connection lost: %v", master.err))
+		default:
+			t.setError(StateError, "Forwarded port not reachable over the shared connection")
+		}
+		t.mutex.Unlock()
+		return false
+	}
+	t.connectedAt = time.Now()
+	t.lastError = ""
+	t.exhaustedEndpoints = 0
+	t.setState(StateConnected, "forward added to shared connection")
+	t.mutex.Unlock()
+
+	// Wake-ups that arrived while connecting are stale now
+	select {
+	case <-t.wake:
+	default:
+	}
+
+	log.Printf("Tunnel '%s' connected over the shared connection on port %s", t.config.Name, port)
+	if t.config.HealthCheck != nil {
+		go t.performHealthCheck()
+	}
+
+	// Hold the forward until the tunnel is stopped or restarted or the master goes away
+	for {
+		t.mutex.RLock()
+		restarting := t.restartReason != ""
+		t.mutex.RUnlock()
+		if restarting {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-master.done:
+		case <-t.wake:
+			continue // Only a restart ends a healthy forward
+		}
+		break
+	}
+
+	t.mutex.Lock()
+	switch {
+	case ctx.Err() != nil:
+		log.Printf("Tunnel '%s' forward removed after stop", t.config.Name)
+	case t.restartReason != "":
+		t.lastError = ""
+		t.setState(StateConnecting, t.restartReason)
+	default:
+		t.setError(StateError, fmt.Sprintf("Shared connection lost: %v", master.err))
+	}
+	t.mutex.Unlock()
+	return true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitForwards(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		master   []string
+		forwards []string
+		wantErr  bool
+	}{
+		{"separate flag", []string{"ssh", "-N", "-L", "8080:localhost:80", "host"},
+			[]string{"ssh", "-N", "host"}, []string{"-L", "8080:localhost:80"}, false},
+		{"grouped flags", []string{"ssh", "-NL", "8080:localhost:80", "host"},
+			[]string{"ssh", "-N", "host"}, []string{"-L", "8080:localhost:80"}, false},
+		{"grouped with attached value", []string{"ssh", "-fNL5432:db:5432", "host"},
+			[]string{"ssh", "-fN", "host"}, []string{"-L", "5432:db:5432"}, false},
+		{"all forward kinds", []string{"ssh", "-L8080:a:80", "-R", "9000:b:90", "-D", "1080", "-p", "2222", "user@host", "uptime"},
+			[]string{"ssh", "-p", "2222", "user@host"}, []string{"-L", "8080:a:80", "-R", "9000:b:90", "-D", "1080"}, false},
+		{"IPv6 bind address", []string{"ssh", "-L", "[::1]:8080:[fd00::2]:80", "host"},
+			[]string{"ssh", "host"}, []string{"-L", "[::1]:8080:[fd00::2]:80"}, false},
+		{"options stay with the master", []string{"ssh", "-i", "key", "-oUser=deploy", "-Np2222", "-L", "1:a:1", "host"},
+			[]string{"ssh", "-i", "key", "-oUser=deploy", "-Np2222", "host"}, []string{"-L", "1:a:1"}, false},
+		{"value that looks like a forward flag", []string{"ssh", "-o", "-L", "host"},
+			[]string{"ssh", "-o", "-L", "host"}, nil, false},
+		{"double dash", []string{"ssh", "-L", "1:a:1", "--", "host"},
+			[]string{"ssh", "--", "host"}, []string{"-L", "1:a:1"}, false},
+		{"no forwards", []string{"ssh", "host"}, []string{"ssh", "host"}, nil, false},
+		{"empty command", nil, nil, nil, true},
+		{"trailing forward flag", []string{"ssh", "-N", "-L"}, nil, nil, true},
+		{"no destination", []string{"ssh", "-NL", "1:a:1"}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		master, forwards, err := splitForwards(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if !reflect.DeepEqual(master, tt.master) || !reflect.DeepEqual(forwards, tt.forwards) {
+			t.Errorf("%s: master %q, forwards %q; want %q, %q", tt.name, master, forwards, tt.master, tt.forwards)
+		}
+	}
+}
+
+func TestMasterKey(t *testing.T) {
+	endpoint := SSHEndpoint{Host: "10.0.0.5", Port: "22", User: "deploy", Alias: "prod"}
+	key := func(endpoint SSHEndpoint, args ...string) string {
+		master, _, err := splitForwards(append([]string{"ssh"}, args...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return masterKey(endpoint, master)
+	}
+	base := key(endpoint, "-i", "key", "-L", "8080:a:80", "prod")
+
+	jumped := endpoint
+	jumped.ProxyJump = "bastion"
+	proxied := endpoint
+	proxied.ProxyCommand = "nc %h %p"
+	otherUser := endpoint
+	otherUser.User = "root"
+	otherPort := endpoint
+	otherPort.Port = "2222"
+
+	tests := []struct {
+		name string
+		key  string
+		same bool
+	}{
+		{"other forwards", key(endpoint, "-i", "key", "-L", "9090:b:90", "-R", "1:c:1", "prod"), true},
+		{"same options", key(endpoint, "-i", "key", "prod"), true},
+		{"other identity", key(endpoint, "-i", "other", "-L", "8080:a:80", "prod"), false},
+		{"extra option", key(endpoint, "-i", "key", "-o", "Compression=yes", "-L", "8080:a:80", "prod"), false},
+		{"no identity", key(endpoint, "-L", "8080:a:80", "prod"), false},
+		{"option order", key(endpoint, "-o", "Compression=yes", "-i", "key", "prod"), false},
+		{"other user", key(otherUser, "-i", "key", "prod"), false},
+		{"other port", key(otherPort, "-i", "key", "prod"), false},
+		{"jump host", key(jumped, "-i", "key", "prod"), false},
+		{"proxy command", key(proxied, "-i", "key", "prod"), false},
+	}
+	for _, tt := range tests {
+		if (tt.key == base) != tt.same {
+			t.Errorf("%s: key %q, base %q, want same = %v", tt.name, tt.key, base, tt.same)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// defaultNetworkProbeTimeout bounds each connectivity probe
+const defaultNetworkProbeTimeout = 3 * time.Second
+
+// errRoutesUnsupported is returned when the routing table cannot be read on this platform
+var errRoutesUnsupported = errors.New("reading the routing table is not supported on this platform")
+
+// NetworkSettings controls how network availability is detected
+type NetworkSettings struct {
+	Probes     []NetworkProbe `json:"probes,omitempty"`     // Checks tried in order; the network is up if any passes
+	SkipGating bool           `json:"skipGating,omitempty"` // Always treat the network as available
+}
+
+// NetworkProbe is a single connectivity check
+type NetworkProbe struct {
+	Type    string   `json:"type"`              // "gateway", "tcp", "dns" or "http"
+	Target  string   `json:"target,omitempty"`  // host:port, hostname or URL depending on the type
+	Timeout Duration `json:"timeout,omitempty"` // Per-probe timeout (default 3s)
+}
+
+// NetworkProbeResult is the outcome of one probe
+type NetworkProbeResult struct {
+	Probe   NetworkProbe `json:"probe"`
+	Success bool         `json:"success"`
+	Message string       `json:"message"`
+	Latency string       `json:"latency"`
+}
+
+// defaultNetworkProbes are used when no probes are configured
+func defaultNetworkProbes() []NetworkProbe {
+	return []NetworkProbe{
+		{Type: "gateway"},
+		{Type: "tcp", Target: "8.8.8.8:53"},
+	}
+}
+
+// validate checks that the network settings are usable
+func (s NetworkSettings) validate() error {
+	for _, probe := range s.Probes {
+		switch probe.Type {
+		case "gateway":
+		case "tcp":
+			if _, _, err := net.SplitHostPort(probe.Target); err != nil {
+				return fmt.Errorf("tcp probe target %q must be host:port", probe.Target)
+			}
+		case "dns", "http":
+			if probe.Target == "" {
+				return fmt.Errorf("%s probe requires a target", probe.Type)
+			}
+		default:
+			return fmt.Errorf("unknown probe type %q", probe.Type)
+		}
+	}
+	return nil
+}
+
+// NetworkProber decides whether the network is usable according to the configured probes
+type NetworkProber struct {
+	probes     []NetworkProbe
+	skipGating bool
+}
+
+// NewNetworkProber creates a prober from the network settings
+func NewNetworkProber(settings NetworkSettings) *NetworkProber {
+	probes := settings.Probes
+	if len(probes) == 0 {
+		probes = defaultNetworkProbes()
+	}
+	return &NetworkProber{
+		probes:     probes,
+		skipGating: settings.SkipGating,
+	}
+}
+
+// Available reports whether any probe succeeds, or true when gating is disabled
+func (p *NetworkProber) Available() bool {
+	if p.skipGating {
+		return true
+	}
+	for _, probe := range p.probes {
+		if runNetworkProbe(probe) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Results runs every probe and reports each outcome
+func (p *NetworkProber) Results() []NetworkProbeResult {
+	results := make([]NetworkProbeResult, 0, len(p.probes))
+	for _, probe := range p.probes {
+		start := time.Now()
+		err := runNetworkProbe(probe)
+
+		result := NetworkProbeResult{
+			Probe:   probe,
+			Success: err == nil,
+			Message: "OK",
+			Latency: time.Since(start).Round(time.Millisecond).String(),
+		}
+		if err != nil {
+			result.Message = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// runNetworkProbe performs a single connectivity check
+func runNetworkProbe(probe NetworkProbe) error {
+	timeout := time.Duration(probe.Timeout)
+	if timeout <= 0 {
+		timeout = defaultNetworkProbeTimeout
+	}
+
+	switch probe.Type {
+	case "gateway":
+		_, iface, err := defaultGateway()
+		if err != nil {
+			return err
+		}
+		link, err := net.InterfaceByName(iface)
+		if err != nil {
+			return fmt.Errorf("default route interface %s: %v", iface, err)
+		}
+		if link.Flags&net.FlagUp == 0 {
+			return fmt.Errorf("default route interface %s is down", iface)
+		}
+		// Point-to-point links such as VPNs may have a default route without a gateway
+		return nil
+
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", probe.Target, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+
+	case "dns":
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupHost(ctx, probe.Target)
+		if err != nil {
+			return err
+		}
+		if len(addrs) == 0 {
+			return fmt.Errorf("%s did not resolve", probe.Target)
+		}
+		return nil
+
+	case "http":
+		client := &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		resp, err := client.Get(probe.Target)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		// Any response at all proves the network path works
+		return nil
+
+	default:
+		return fmt.Errorf("unknown probe type %q", probe.Type)
+	}
+}
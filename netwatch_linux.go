@@ -0,0 +1,204 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// rtnetlink multicast groups from <linux/rtnetlink.h>, which package syscall does not export
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4Ifaddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6Ifaddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// netlinkGroups are the rtnetlink groups for link, address and route changes
+const netlinkGroups = rtmgrpLink | rtmgrpIPv4Ifaddr | rtmgrpIPv6Ifaddr | rtmgrpIPv4Route | rtmgrpIPv6Route
+
+// rtTableMain is the main routing table; the local and cache tables are too noisy to watch
+const rtTableMain = 254
+
+// watchNetworkChanges subscribes to rtnetlink and sends every link, address and route
+// change to changes until ctx is cancelled
+func watchNetworkChanges(ctx context.Context, changes chan<- NetworkChange) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("netlink socket: %v", err)
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: netlinkGroups}); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("netlink bind: %v", err)
+	}
+
+	// Closing the socket does not interrupt a blocked read, so wake up periodically to check ctx
+	timeout := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("netlink timeout: %v", err)
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+
+		buf := make([]byte, 64*1024)
+		for ctx.Err() == nil {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK || err == syscall.EINTR {
+					continue
+				}
+				if err == syscall.ENOBUFS {
+					// The kernel dropped messages; report a generic change so we re-probe
+					sendNetworkChange(changes, NetworkChange{Kind: "link", Action: "changed", Detail: "netlink overrun"})
+					continue
+				}
+				log.Printf("Netlink receive failed, network change events stopped: %v", err)
+				return
+			}
+
+			messages, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for i := range messages {
+				if change, ok := parseNetlinkChange(&messages[i]); ok {
+					sendNetworkChange(changes, change)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// sendNetworkChange delivers a change without blocking the netlink reader
+func sendNetworkChange(changes chan<- NetworkChange, change NetworkChange) {
+	select {
+	case changes <- change:
+	default:
+	}
+}
+
+// parseNetlinkChange converts an rtnetlink message into a NetworkChange
+func parseNetlinkChange(msg *syscall.NetlinkMessage) (NetworkChange, bool) {
+	switch msg.Header.Type {
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		return parseLinkChange(msg)
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		return parseAddrChange(msg)
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		return parseRouteChange(msg)
+	}
+	return NetworkChange{}, false
+}
+
+// parseLinkChange handles interfaces appearing, disappearing or changing state
+func parseLinkChange(msg *syscall.NetlinkMessage) (NetworkChange, bool) {
+	if len(msg.Data) < syscall.SizeofIfInfomsg {
+		return NetworkChange{}, false
+	}
+	info := (*syscall.IfInfomsg)(unsafe.Pointer(&msg.Data[0]))
+
+	change := NetworkChange{Kind: "link", Interface: interfaceName(int(info.Index))}
+	if attrs, err := syscall.ParseNetlinkRouteAttr(msg); err == nil {
+		for _, attr := range attrs {
+			if attr.Attr.Type == syscall.IFLA_IFNAME {
+				change.Interface = cString(attr.Value)
+			}
+		}
+	}
+
+	switch {
+	case msg.Header.Type == syscall.RTM_DELLINK:
+		change.Action = "removed"
+	case info.Flags&syscall.IFF_UP != 0 && info.Flags&syscall.IFF_RUNNING != 0:
+		change.Action = "up"
+	default:
+		change.Action = "down"
+	}
+	return change, true
+}
+
+// parseAddrChange handles addresses being added to or removed from an interface
+func parseAddrChange(msg *syscall.NetlinkMessage) (NetworkChange, bool) {
+	if len(msg.Data) < syscall.SizeofIfAddrmsg {
+		return NetworkChange{}, false
+	}
+	info := (*syscall.IfAddrmsg)(unsafe.Pointer(&msg.Data[0]))
+
+	change := NetworkChange{
+		Kind:      "address",
+		Action:    "added",
+		Interface: interfaceName(int(info.Index)),
+	}
+	if msg.Header.Type == syscall.RTM_DELADDR {
+		change.Action = "removed"
+	}
+
+	if attrs, err := syscall.ParseNetlinkRouteAttr(msg); err == nil {
+		for _, attr := range attrs {
+			if attr.Attr.Type == syscall.IFA_LOCAL || (attr.Attr.Type == syscall.IFA_ADDRESS && change.Detail == "") {
+				change.Detail = fmt.Sprintf("%s/%d", net.IP(attr.Value), info.Prefixlen)
+			}
+		}
+	}
+	return change, true
+}
+
+// parseRouteChange handles routes in the main table being added or removed
+func parseRouteChange(msg *syscall.NetlinkMessage) (NetworkChange, bool) {
+	if len(msg.Data) < syscall.SizeofRtMsg {
+		return NetworkChange{}, false
+	}
+	info := (*syscall.RtMsg)(unsafe.Pointer(&msg.Data[0]))
+	if info.Table != rtTableMain {
+		return NetworkChange{}, false
+	}
+
+	change := NetworkChange{Kind: "route", Action: "added", Detail: "default"}
+	if msg.Header.Type == syscall.RTM_DELROUTE {
+		change.Action = "removed"
+	}
+
+	if attrs, err := syscall.ParseNetlinkRouteAttr(msg); err == nil {
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.RTA_DST:
+				change.Detail = fmt.Sprintf("%s/%d", net.IP(attr.Value), info.Dst_len)
+			case syscall.RTA_OIF:
+				if len(attr.Value) >= 4 {
+					change.Interface = interfaceName(int(binary.NativeEndian.Uint32(attr.Value)))
+				}
+			}
+		}
+	}
+	return change, true
+}
+
+// interfaceName resolves an interface index, falling back to the index itself
+func interfaceName(index int) string {
+	if iface, err := net.InterfaceByIndex(index); err == nil {
+		return iface.Name
+	}
+	return fmt.Sprintf("if%d", index)
+}
+
+// cString trims a NUL-terminated netlink string attribute
+func cString(value []byte) string {
+	for i, b := range value {
+		if b == 0 {
+			return string(value[:i])
+		}
+	}
+	return string(value)
+}
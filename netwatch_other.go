@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// watchNetworkChanges is only implemented on Linux; other platforms poll instead
+func watchNetworkChanges(_ context.Context, _ chan<- NetworkChange) error {
+	return errors.New("netlink is not available on this platform")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// On-demand tunnel defaults
+const (
+	defaultOnDemandIdle   = 5 * time.Minute  // Session teardown after this long without connections
+	defaultOnDemandHold   = 30 * time.Second // How long the first client waits for ssh to connect
+	onDemandCheckInterval = 5 * time.Second
+)
+
+// validateOnDemand checks the on-demand settings
+func validateOnDemand(config TunnelConfig) error {
+	if config.OnDemandIdle < 0 {
+		return fmt.Errorf("onDemandIdle must not be negative")
+	}
+	if !config.OnDemand {
+		if config.OnDemandIdle > 0 {
+			return fmt.Errorf("onDemandIdle requires onDemand")
+		}
+		return nil
+	}
+	if config.Listener == ListenerSSH {
+		return fmt.Errorf("onDemand tunnels need easytunnel to own the local port, not %q", ListenerSSH)
+	}
+	return nil
+}
+
+// isOnDemand reports whether ssh is started by the first inbound connection
+func (t *Tunnel) isOnDemand() bool {
+	return t.config.OnDemand
+}
+
+// onDemandIdle returns how long an on-demand session may go without connections
+func (t *Tunnel) onDemandIdle() time.Duration {
+	if t.config.OnDemandIdle > 0 {
+		return time.Duration(t.config.OnDemandIdle)
+	}
+	return defaultOnDemandIdle
+}
+
+// holdTimeout returns how long a client waits for the tunnel to become ready
+func (t *Tunnel) holdTimeout() time.Duration {
+	if t.config.HoldConnections > 0 {
+		return time.Duration(t.config.HoldConnections)
+	}
+	if t.isOnDemand() {
+		return defaultOnDemandHold
+	}
+	return 0
+}
+
+// Activate asks an idle on-demand tunnel to start its ssh session
+func (t *Tunnel) Activate() {
+	if t.activate == nil {
+		return
+	}
+	select {
+	case t.activate <- struct{}{}:
+	default:
+		// An activation is already pending
+	}
+}
+
+// standby replaces the maintenance loop for on-demand tunnels. It listens on the
+// local port without ssh, runs the maintenance loop once a client connects and
+// returns to listening after the session has been idle for onDemandIdle.
+func (t *Tunnel) standby(ctx context.Context) {
+	retry := newBackoff(t.retryPolicy())
+	reason := "listening for the first connection"
+
+	for {
+		if err := t.listenOnDemand(ctx, reason); err != nil {
+			t.mutex.Lock()
+			if ctx.Err() == nil {
+				t.setError(StateError, fmt.Sprintf("Local port unavailable: %v", err))
+			}
+			t.mutex.Unlock()
+
+			if !t.waitForRetry(ctx, retry) {
+				return
+			}
+			continue
+		}
+		t.resetRetry(retry)
+
+		// A wake-up for an idle tunnel has nothing to interrupt
+		select {
+		case <-t.wake:
+		default:
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.activate:
+		}
+
+		t.mutex.Lock()
+		t.setState(StateConnecting, "activated by an incoming connection")
+		t.mutex.Unlock()
+		log.Printf("Tunnel '%s' activated by an incoming connection", t.config.Name)
+
+		session, teardown := context.WithCancel(ctx)
+		go t.watchIdle(session, teardown)
+		t.maintain(session)
+		teardown()
+
+		if ctx.Err() != nil {
+			return
+		}
+		reason = fmt.Sprintf("no connections for %s, ssh session closed", t.onDemandIdle())
+		log.Printf("Tunnel '%s' idle, ssh session closed", t.config.Name)
+	}
+}
+
+// listenOnDemand opens the local listener, reclaiming the port under the tunnel's
+// port policy, and marks the tunnel idle
+func (t *Tunnel) listenOnDemand(ctx context.Context, reason string) error {
+	port := t.reusableListener()
+	listening := port != ""
+	if !listening {
+		var err error
+		if port, err = t.reclaimPort(ctx); err != nil {
+			return err
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Stop closes the listener under the lock, so never open one after it
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !listening {
+		if port == t.config.LocalPort {
+			t.boundPort = ""
+		} else {
+			t.boundPort = port
+		}
+		if err := t.openListener(port); err != nil {
+			return err
+		}
+	}
+
+	t.lastError = ""
+	t.attempt = 0
+	t.nextRetryAt = time.Time{}
+	t.setState(StateIdle, reason)
+	return nil
+}
+
+// watchIdle ends an on-demand session once no client has been connected or waiting
+// for onDemandIdle
+func (t *Tunnel) watchIdle(ctx context.Context, teardown context.CancelFunc) {
+	idle := t.onDemandIdle()
+	idleSince := time.Now()
+
+	ticker := time.NewTicker(onDemandCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if t.traffic.active.Load() > 0 || t.traffic.held.Load() > 0 {
+			idleSince = time.Now()
+			continue
+		}
+		if time.Since(idleSince) >= idle {
+			teardown()
+			return
+		}
+	}
+}
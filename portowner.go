@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PortProcess is a TCP socket on a local port together with the process that owns it.
+// PID is 0 when the owner cannot be determined, for example a socket of another user
+// while not running as root, or a connection in TIME_WAIT.
+type PortProcess struct {
+	PID          int    `json:"pid"`
+	User         string `json:"user,omitempty"`
+	Command      string `json:"command,omitempty"`
+	Exe          string `json:"exe,omitempty"`
+	Cmdline      string `json:"cmdline,omitempty"`
+	State        string `json:"state,omitempty"`        // TCP state such as LISTEN or ESTABLISHED
+	Protocol     string `json:"protocol,omitempty"`     // "tcp" or "tcp6"
+	LocalAddress string `json:"localAddress,omitempty"` // host:port the socket is bound to
+}
+
+// String describes the process for logs and history entries
+func (p PortProcess) String() string {
+	name := p.Command
+	if name == "" {
+		name = "unknown"
+	}
+	if p.PID == 0 {
+		if p.User != "" {
+			return fmt.Sprintf("%s (user %s)", name, p.User)
+		}
+		return name
+	}
+	if p.User != "" {
+		return fmt.Sprintf("%s (pid %d, user %s)", name, p.PID, p.User)
+	}
+	return fmt.Sprintf("%s (pid %d)", name, p.PID)
+}
+
+// findPortSockets returns every TCP socket whose local port is port, IPv4 and IPv6,
+// with its owning process, sorted by PID
+func findPortSockets(port string) ([]PortProcess, error) {
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+
+	sockets, err := portSockets(number)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(sockets, func(i, j int) bool { return sockets[i].PID < sockets[j].PID })
+	return sockets, nil
+}
+
+// listenersOnPort returns the processes whose listening sockets occupy port for the
+// bind address host, one entry per process
+func listenersOnPort(host, port string) []PortProcess {
+	sockets, err := findPortSockets(port)
+	if err != nil {
+		return nil
+	}
+
+	var listeners []PortProcess
+	seen := make(map[int]bool)
+	for _, socket := range sockets {
+		if socket.State != "LISTEN" || !listenerConflicts(socket, host) {
+			continue
+		}
+		if socket.PID != 0 {
+			if seen[socket.PID] {
+				continue
+			}
+			seen[socket.PID] = true
+		}
+		listeners = append(listeners, socket)
+	}
+	return listeners
+}
+
+// getProcessesUsingPort returns the PIDs of processes with a socket on the specified port
+func getProcessesUsingPort(port string) ([]int, error) {
+	sockets, err := findPortSockets(port)
+	if err != nil {
+		return nil, err
+	}
+
+	pids := []int{}
+	seen := make(map[int]bool)
+	for _, socket := range sockets {
+		if socket.PID == 0 || seen[socket.PID] {
+			continue
+		}
+		seen[socket.PID] = true
+		pids = append(pids, socket.PID)
+	}
+	return pids, nil
+}
+
+// getProcessInfoForPort formats the sockets on a port as a table for logs
+func getProcessInfoForPort(port string) string {
+	sockets, err := findPortSockets(port)
+	if err != nil {
+		return fmt.Sprintf("Could not get process info for port %s: %v", port, err)
+	}
+	if len(sockets) == 0 {
+		return fmt.Sprintf("No sockets on port %s", port)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-16s %7s %-12s %-5s %-12s %s\n", "COMMAND", "PID", "USER", "PROTO", "STATE", "ADDRESS")
+	for _, s := range sockets {
+		command := s.Command
+		if command == "" {
+			command = "-"
+		}
+		fmt.Fprintf(&b, "%-16s %7d %-12s %-5s %-12s %s\n", command, s.PID, s.User, s.Protocol, s.State, s.LocalAddress)
+	}
+	return b.String()
+}
+
+// hasUnknownOwner reports whether any of the processes could not be identified
+func hasUnknownOwner(processes []PortProcess) bool {
+	for _, process := range processes {
+		if process.PID == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// userName resolves a numeric uid, falling back to the number itself
+func userName(uid string) string {
+	if u, err := user.LookupId(uid); err == nil {
+		return u.Username
+	}
+	return uid
+}
+
+// maxProcessDepth bounds the walk up the process tree
+const maxProcessDepth = 64
+
+// isDescendant reports whether pid is ancestor itself or one of its descendants
+func isDescendant(pid, ancestor int) bool {
+	for depth := 0; depth < maxProcessDepth && pid > 1; depth++ {
+		if pid == ancestor {
+			return true
+		}
+		parent, err := parentPID(pid)
+		if err != nil {
+			return false
+		}
+		pid = parent
+	}
+	return pid == ancestor
+}
+
+// foreignListeners returns the listeners occupying host:port that do not belong to the
+// ssh process or its children. Listeners whose owner cannot be seen count as foreign,
+// since our own ssh child is always visible to us.
+func foreignListeners(host, port string, sshPID int) ([]PortProcess, error) {
+	sockets, err := findPortSockets(port)
+	if err != nil {
+		return nil, err
+	}
+
+	var foreign []PortProcess
+	for _, socket := range sockets {
+		if socket.State != "LISTEN" || !listenerConflicts(socket, host) {
+			continue
+		}
+		if socket.PID != 0 && isDescendant(socket.PID, sshPID) {
+			continue
+		}
+		foreign = append(foreign, socket)
+	}
+	return foreign, nil
+}
+
+// checkListenerOwner returns a description of whoever else listens on ssh's forward,
+// or "" if only ssh does or ownership cannot be determined on this system
+func checkListenerOwner(host, port string, sshPID int) (string, []PortProcess) {
+	foreign, err := foreignListeners(host, port, sshPID)
+	if err != nil || len(foreign) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("Port %s hijacked by %s", port, describeHolders(foreign)), foreign
+}
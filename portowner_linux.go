@@ -0,0 +1,232 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tcpStates maps the state column of /proc/net/tcp to names as printed by ss and lsof
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// procSocket is one row of /proc/net/tcp or /proc/net/tcp6
+type procSocket struct {
+	protocol string
+	local    string
+	state    string
+	uid      string
+	inode    string
+}
+
+// portSockets reads /proc/net/tcp and /proc/net/tcp6 and maps socket inodes to
+// processes through /proc/*/fd
+func portSockets(port int) ([]PortProcess, error) {
+	sockets, err := readProcNetTCP("/proc/net/tcp", "tcp", port)
+	if err != nil {
+		return nil, err
+	}
+	if sockets6, err := readProcNetTCP("/proc/net/tcp6", "tcp6", port); err == nil {
+		sockets = append(sockets, sockets6...)
+	}
+	if len(sockets) == 0 {
+		return []PortProcess{}, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, socket := range sockets {
+		if socket.inode != "0" {
+			wanted[socket.inode] = true
+		}
+	}
+	owners := socketOwners(wanted)
+
+	var result []PortProcess
+	for _, socket := range sockets {
+		base := PortProcess{
+			User:         userName(socket.uid),
+			State:        socket.state,
+			Protocol:     socket.protocol,
+			LocalAddress: socket.local,
+		}
+
+		pids := owners[socket.inode]
+		if len(pids) == 0 {
+			result = append(result, base)
+			continue
+		}
+		for _, pid := range pids {
+			record := describeProcess(pid)
+			record.State = base.State
+			record.Protocol = base.Protocol
+			record.LocalAddress = base.LocalAddress
+			if record.User == "" {
+				record.User = base.User
+			}
+			result = append(result, record)
+		}
+	}
+	return result, nil
+}
+
+// readProcNetTCP returns the sockets in a /proc/net/tcp style file bound to port
+func readProcNetTCP(path, protocol string, port int) ([]procSocket, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var sockets []procSocket
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		ip, localPort, ok := parseProcAddress(fields[1])
+		if !ok || localPort != port {
+			continue
+		}
+
+		state := tcpStates[strings.ToUpper(fields[3])]
+		if state == "" {
+			state = fields[3]
+		}
+
+		sockets = append(sockets, procSocket{
+			protocol: protocol,
+			local:    net.JoinHostPort(ip.String(), strconv.Itoa(localPort)),
+			state:    state,
+			uid:      fields[7],
+			inode:    fields[9],
+		})
+	}
+	return sockets, scanner.Err()
+}
+
+// parseProcAddress decodes "0100007F:1538". The address is stored as native-endian
+// 32-bit words, which is little-endian on every platform we build for.
+func parseProcAddress(value string) (net.IP, int, bool) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return nil, 0, false
+	}
+
+	raw, err := hex.DecodeString(parts[0])
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return nil, 0, false
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	ip := make(net.IP, len(raw))
+	for word := 0; word < len(raw); word += 4 {
+		for i := 0; i < 4; i++ {
+			ip[word+i] = raw[word+3-i]
+		}
+	}
+	return ip, int(port), true
+}
+
+// socketOwners scans /proc/*/fd for the wanted socket inodes. Processes we may not
+// inspect (other users without root) are skipped.
+func socketOwners(wanted map[string]bool) map[string][]int {
+	owners := make(map[string][]int)
+	if len(wanted) == 0 {
+		return owners
+	}
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return owners
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		fdDir := filepath.Join("/proc", entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+			if wanted[inode] {
+				owners[inode] = append(owners[inode], pid)
+			}
+		}
+	}
+	return owners
+}
+
+// describeProcess reads the owner, name, executable and command line of a process from /proc
+func describeProcess(pid int) PortProcess {
+	process := PortProcess{PID: pid}
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+
+	if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		process.Command = strings.TrimSpace(string(comm))
+	}
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		process.Exe = exe
+	}
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		process.Cmdline = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	}
+	if status, err := os.ReadFile(filepath.Join(dir, "status")); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "Uid:" {
+				process.User = userName(fields[1])
+				break
+			}
+		}
+	}
+	return process
+}
+
+// parentPID reads the parent of a process from /proc/<pid>/stat
+func parentPID(pid int) (int, error) {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// The command name is in parentheses and may contain spaces, so parse after it
+	end := strings.LastIndexByte(string(stat), ')')
+	if end < 0 {
+		return 0, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	return strconv.Atoi(fields[1])
+}
@@ -0,0 +1,154 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const procNetHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+func TestParseProcAddress(t *testing.T) {
+	tests := []struct {
+		value string
+		ip    string
+		port  int
+		ok    bool
+	}{
+		{"0100007F:1538", "127.0.0.1", 5432, true},
+		{"0200007F:1F90", "127.0.0.2", 8080, true},
+		{"00000000:0016", "0.0.0.0", 22, true},
+		{"0101A8C0:01BB", "192.168.1.1", 443, true},
+		{"00000000000000000000000001000000:1538", "::1", 5432, true},
+		{"00000000000000000000000000000000:1F90", "::", 8080, true},
+		{"000000FD000000000000000002000000:0050", "fd00::2", 80, true},
+		{"0000000000000000FFFF00000100007F:1538", "127.0.0.1", 5432, true},
+		{"0100007f:1538", "127.0.0.1", 5432, true},
+		{"0100007F", "", 0, false},
+		{"0100007F:1538:1", "", 0, false},
+		{"0100007G:1538", "", 0, false},
+		{"01007F:1538", "", 0, false},
+		{"0100007F:10000", "", 0, false},
+		{"0100007F:", "", 0, false},
+	}
+	for _, tt := range tests {
+		ip, port, ok := parseProcAddress(tt.value)
+		if ok != tt.ok {
+			t.Errorf("parseProcAddress(%q) ok = %v, want %v", tt.value, ok, tt.ok)
+			continue
+		}
+		if ok && (ip.String() != tt.ip || port != tt.port) {
+			t.Errorf("parseProcAddress(%q) = %s, %d, want %s, %d", tt.value, ip, port, tt.ip, tt.port)
+		}
+	}
+}
+
+func TestReadProcNetTCP(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, lines ...string) string {
+		content := procNetHeader
+		for _, line := range lines {
+			content += line + "\n"
+		}
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	tcp := write("tcp",
+		"   0: 0100007F:1538 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 41001 1 0000000000000000 100 0 0 10 0",
+		"   1: 0200007F:1538 00000000:0000 0a 00000000:00000000 00:00000000 00000000     0        0 41002 1 0000000000000000 100 0 0 10 0",
+		"   2: 0100007F:1538 0100007F:D431 01 00000000:00000000 00:00000000 00000000  1000        0 41003 1 0000000000000000 20 4 30 10 -1",
+		"   3: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 41004 1 0000000000000000 100 0 0 10 0",
+		"   4: 0100007F:D431 0100007F:1538 06 00000000:00000000 03:00000F9C 00000000     0        0 0 3 0000000000000000",
+		"   5: garbage",
+		"   6: 0100007F 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 41005 1",
+	)
+	tcp6 := write("tcp6",
+		"   0: 00000000000000000000000001000000:1538 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 42001 1 0000000000000000 100 0 0 10 0",
+		"   1: 00000000000000000000000000000000:1538 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 42002 1 0000000000000000 100 0 0 10 0",
+		"   2: 0000000000000000FFFF00000100007F:1538 0000000000000000FFFF00000100007F:C350 08 00000000:00000000 00:00000000 00000000  1000        0 42003 1 0000000000000000 20 4 30 10 -1",
+		"   3: 000000FD000000000000000002000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 42004 1 0000000000000000 100 0 0 10 0",
+	)
+
+	tests := []struct {
+		name     string
+		file     string
+		protocol string
+		port     int
+		want     []procSocket
+	}{
+		{"tcp", tcp, "tcp", 5432, []procSocket{
+			{protocol: "tcp", local: "127.0.0.1:5432", state: "LISTEN", uid: "1000", inode: "41001"},
+			{protocol: "tcp", local: "127.0.0.2:5432", state: "LISTEN", uid: "0", inode: "41002"},
+			{protocol: "tcp", local: "127.0.0.1:5432", state: "ESTABLISHED", uid: "1000", inode: "41003"},
+		}},
+		{"tcp other port", tcp, "tcp", 22, []procSocket{
+			{protocol: "tcp", local: "0.0.0.0:22", state: "LISTEN", uid: "0", inode: "41004"},
+		}},
+		{"tcp client side", tcp, "tcp", 54321, []procSocket{
+			{protocol: "tcp", local: "127.0.0.1:54321", state: "TIME_WAIT", uid: "0", inode: "0"},
+		}},
+		{"tcp unused port", tcp, "tcp", 9999, nil},
+		{"tcp6", tcp6, "tcp6", 5432, []procSocket{
+			{protocol: "tcp6", local: "[::1]:5432", state: "LISTEN", uid: "1000", inode: "42001"},
+			{protocol: "tcp6", local: "[::]:5432", state: "LISTEN", uid: "0", inode: "42002"},
+			{protocol: "tcp6", local: "127.0.0.1:5432", state: "CLOSE_WAIT", uid: "1000", inode: "42003"},
+		}},
+		{"tcp6 unique local", tcp6, "tcp6", 80, []procSocket{
+			{protocol: "tcp6", local: "[fd00::2]:80", state: "LISTEN", uid: "0", inode: "42004"},
+		}},
+	}
+	for _, tt := range tests {
+		sockets, err := readProcNetTCP(tt.file, tt.protocol, tt.port)
+		if err != nil {
+			t.Errorf("%s: error = %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(sockets, tt.want) {
+			t.Errorf("%s: sockets = %+v, want %+v", tt.name, sockets, tt.want)
+		}
+	}
+
+	if _, err := readProcNetTCP(filepath.Join(dir, "missing"), "tcp", 5432); err == nil {
+		t.Errorf("missing file: no error")
+	}
+}
+
+func TestReadProcNetTCPListeners(t *testing.T) {
+	// Only LISTEN rows own a port; established and closing rows on it are clients
+	file := filepath.Join(t.TempDir(), "tcp")
+	content := procNetHeader +
+		"   0: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1 1\n" +
+		"   1: 0100007F:1F90 0100007F:C001 01 00000000:00000000 00:00000000 00000000  1000        0 2 1\n" +
+		"   2: 0100007F:1F90 0100007F:C002 06 00000000:00000000 00:00000000 00000000  1000        0 0 1\n" +
+		"   3: 0100007F:1F90 0100007F:C003 0C 00000000:00000000 00:00000000 00000000  1000        0 3 1\n"
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sockets, err := readProcNetTCP(file, "tcp", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var listening, states []string
+	for _, socket := range sockets {
+		states = append(states, socket.state)
+		if socket.state == "LISTEN" {
+			listening = append(listening, socket.inode)
+		}
+	}
+	if !reflect.DeepEqual(listening, []string{"1"}) {
+		t.Errorf("listening inodes = %v, want [1]", listening)
+	}
+	// Unknown state codes are kept as written rather than dropped
+	if want := []string{"LISTEN", "ESTABLISHED", "TIME_WAIT", "0C"}; !reflect.DeepEqual(states, want) {
+		t.Errorf("states = %v, want %v", states, want)
+	}
+}
@@ -0,0 +1,114 @@
+//go:build !linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// portSockets asks lsof for the TCP sockets on port using its machine-readable -F
+// output; there is no /proc to read on these platforms
+func portSockets(port int) ([]PortProcess, error) {
+	if _, err := exec.LookPath("lsof"); err != nil {
+		return nil, fmt.Errorf("lsof not found: %v", err)
+	}
+
+	output, err := exec.Command("lsof", "-nP", "-iTCP:"+strconv.Itoa(port), "-F", "pcuftnT").Output()
+	if err != nil {
+		// lsof exits 1 when nothing matches
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return []PortProcess{}, nil
+		}
+		return nil, fmt.Errorf("lsof failed: %v", err)
+	}
+
+	suffix := ":" + strconv.Itoa(port)
+	result := []PortProcess{}
+
+	var process, socket PortProcess
+	inSocket := false
+	flush := func() {
+		if inSocket && strings.HasSuffix(socket.LocalAddress, suffix) {
+			result = append(result, socket)
+		}
+		inSocket = false
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		value := line[1:]
+
+		switch line[0] {
+		case 'p':
+			flush()
+			pid, _ := strconv.Atoi(value)
+			process = PortProcess{PID: pid}
+		case 'c':
+			process.Command = value
+		case 'u':
+			process.User = userName(value)
+		case 'f':
+			flush()
+			socket = process
+			inSocket = true
+		case 't':
+			socket.Protocol = "tcp"
+			if value == "IPv6" {
+				socket.Protocol = "tcp6"
+			}
+		case 'n':
+			// "local->remote" for connections, just "local" for listeners
+			socket.LocalAddress = strings.SplitN(value, "->", 2)[0]
+		case 'T':
+			if strings.HasPrefix(value, "ST=") {
+				socket.State = strings.TrimPrefix(value, "ST=")
+			}
+		}
+	}
+	flush()
+
+	// lsof has no executable path or full command line; fill them in from ps
+	for i := range result {
+		details := describeProcess(result[i].PID)
+		result[i].Cmdline = details.Cmdline
+		if result[i].User == "" {
+			result[i].User = details.User
+		}
+	}
+	return result, nil
+}
+
+// describeProcess looks up the owner, name and command line of a process with ps
+func describeProcess(pid int) PortProcess {
+	process := PortProcess{PID: pid}
+	id := strconv.Itoa(pid)
+
+	if output, err := exec.Command("ps", "-o", "user=", "-o", "comm=", "-p", id).Output(); err == nil {
+		if fields := strings.Fields(string(output)); len(fields) >= 2 {
+			process.User = fields[0]
+			process.Command = filepath.Base(strings.Join(fields[1:], " "))
+		}
+	}
+	if output, err := exec.Command("ps", "-o", "args=", "-p", id).Output(); err == nil {
+		process.Cmdline = strings.TrimSpace(string(output))
+	}
+	return process
+}
+
+// parentPID asks ps for the parent of a process
+func parentPID(pid int) (int, error) {
+	output, err := exec.Command("ps", "-o", "ppid=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(output)))
+}
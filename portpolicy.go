@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Port policies decide what happens when a tunnel's local port is already taken
+const (
+	PortPolicyFail         = "fail"              // Report the conflict and retry later
+	PortPolicyKillOwnSSH   = "kill-own-ssh-only" // Kill only ssh processes forwarding the same port
+	PortPolicyKillAny      = "kill-any"          // Kill whatever holds the port (needs confirmKillAny)
+	PortPolicyWait         = "wait"              // Wait for the holder to release the port
+	PortPolicyNextFreePort = "next-free-port"    // Bind the next free port instead
+)
+
+// defaultPortPolicy only ever kills stale ssh forwards, never unrelated services
+const defaultPortPolicy = PortPolicyKillOwnSSH
+
+// Port reclamation tuning
+const (
+	portWaitTimeout    = 30 * time.Second
+	portSearchRange    = 100
+	portReleaseTimeout = 2 * time.Second
+)
+
+// validatePortPolicy checks the policy name and that kill-any was confirmed
+func validatePortPolicy(config TunnelConfig) error {
+	switch config.PortPolicy {
+	case "", PortPolicyFail, PortPolicyKillOwnSSH, PortPolicyWait, PortPolicyNextFreePort:
+		return nil
+	case PortPolicyKillAny:
+		if !config.ConfirmKillAny {
+			return fmt.Errorf("port policy %q kills any process on port %s and requires confirmKillAny", PortPolicyKillAny, config.LocalPort)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown port policy %q", config.PortPolicy)
+	}
+}
+
+// portPolicy returns the tunnel's policy, falling back to the default
+func (t *Tunnel) portPolicy() string {
+	if t.config.PortPolicy == "" {
+		return defaultPortPolicy
+	}
+	return t.config.PortPolicy
+}
+
+// reclaimPort makes the local port usable according to the tunnel's port policy and
+// returns the port ssh should bind, which differs from the configured one only under
+// next-free-port. Every decision is recorded in the tunnel's history.
+func (t *Tunnel) reclaimPort(ctx context.Context) (string, error) {
+	host, port := t.bindHost(), t.config.LocalPort
+	if isBindAvailable(host, port) {
+		return port, nil
+	}
+
+	holders := listenersOnPort(host, port)
+	policy := t.portPolicy()
+	log.Printf("Port %s for tunnel '%s' is in use by %s (policy %s)", port, t.config.Name, describeHolders(holders), policy)
+
+	switch policy {
+	case PortPolicyFail:
+		t.recordPortEvent("port-conflict", fmt.Sprintf("port %s in use by %s, not reclaiming", port, describeHolders(holders)), holders)
+		return "", fmt.Errorf("port %s is in use by %s", port, describeHolders(holders))
+
+	case PortPolicyKillOwnSSH:
+		var victims, others []PortProcess
+		for _, holder := range holders {
+			if isSSHForwarding(holder, port) {
+				victims = append(victims, holder)
+			} else {
+				others = append(others, holder)
+			}
+		}
+		if len(victims) == 0 || len(others) > 0 {
+			t.recordPortEvent("port-conflict", fmt.Sprintf("port %s in use by %s, which is not a stale ssh forward", port, describeHolders(others)), holders)
+			return "", fmt.Errorf("port %s is in use by %s; only stale ssh forwards are killed under policy %s", port, describeHolders(others), policy)
+		}
+		return port, t.killPortHolders(host, port, victims)
+
+	case PortPolicyKillAny:
+		if len(holders) == 0 || hasUnknownOwner(holders) {
+			t.recordPortEvent("port-conflict", fmt.Sprintf("port %s in use by a process that cannot be identified", port), nil)
+			return "", fmt.Errorf("port %s is in use by a process that cannot be identified", port)
+		}
+		return port, t.killPortHolders(host, port, holders)
+
+	case PortPolicyWait:
+		return port, t.waitForPort(ctx, host, port, holders)
+
+	case PortPolicyNextFreePort:
+		next, err := nextFreePort(host, port)
+		if err != nil {
+			t.recordPortEvent("port-conflict", err.Error(), holders)
+			return "", err
+		}
+		t.recordPortEvent("port-reassigned", fmt.Sprintf("port %s in use by %s, binding %s instead", port, describeHolders(holders), next), holders)
+		log.Printf("Tunnel '%s' binding port %s instead of %s", t.config.Name, next, port)
+		return next, nil
+	}
+
+	return "", fmt.Errorf("unknown port policy %q", policy)
+}
+
+// recordPortEvent adds a port reclamation entry to the tunnel history
+func (t *Tunnel) recordPortEvent(action, reason string, processes []PortProcess) {
+	t.mutex.Lock()
+	t.recordEvent(action, reason, processes)
+	t.mutex.Unlock()
+}
+
+// killPortHolders terminates the given processes, escalating to SIGKILL, and checks
+// that the port was released
+func (t *Tunnel) killPortHolders(host, port string, victims []PortProcess) error {
+	t.recordPortEvent("port-reclaimed", fmt.Sprintf("killing %s to free port %s", describeHolders(victims), port), victims)
+
+	for _, victim := range victims {
+		log.Printf("Tunnel '%s': sending TERM to %s holding port %s", t.config.Name, victim, port)
+		exec.Command("kill", "-TERM", strconv.Itoa(victim.PID)).Run()
+	}
+
+	deadline := time.Now().Add(portReleaseTimeout)
+	for time.Now().Before(deadline) && !isBindAvailable(host, port) {
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	if !isBindAvailable(host, port) {
+		for _, victim := range victims {
+			log.Printf("Tunnel '%s': force killing %s", t.config.Name, victim)
+			exec.Command("kill", "-KILL", strconv.Itoa(victim.PID)).Run()
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	if !isBindAvailable(host, port) {
+		return fmt.Errorf("port %s is still in use after killing %s", port, describeHolders(victims))
+	}
+	log.Printf("Successfully freed port %s for tunnel '%s'", port, t.config.Name)
+	return nil
+}
+
+// waitForPort waits for the current holder to release the port
+func (t *Tunnel) waitForPort(ctx context.Context, host, port string, holders []PortProcess) error {
+	reason := fmt.Sprintf("Waiting for port %s held by %s", port, describeHolders(holders))
+	t.mutex.Lock()
+	t.recordEvent("port-wait", reason, holders)
+	if ctx.Err() == nil {
+		t.setState(StateWaiting, reason)
+	}
+	t.mutex.Unlock()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	timeout := time.NewTimer(portWaitTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return fmt.Errorf("port %s still in use by %s after %s", port, describeHolders(holders), portWaitTimeout)
+		case <-ticker.C:
+			if isBindAvailable(host, port) {
+				return nil
+			}
+		}
+	}
+}
+
+// nextFreePort finds the first port above port that is free on host
+func nextFreePort(host, port string) (string, error) {
+	base, err := strconv.Atoi(port)
+	if err != nil {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	for candidate := base + 1; candidate <= base+portSearchRange && candidate <= 65535; candidate++ {
+		if isBindAvailable(host, strconv.Itoa(candidate)) {
+			return strconv.Itoa(candidate), nil
+		}
+	}
+	return "", fmt.Errorf("no free port between %d and %d", base+1, base+portSearchRange)
+}
+
+// isSSHForwarding reports whether the process is an ssh client with a -L forward on port
+func isSSHForwarding(process PortProcess, port string) bool {
+	if process.Command != "ssh" || process.Cmdline == "" {
+		return false
+	}
+	args, err := parseSSHCommand(process.Cmdline)
+	if err != nil {
+		return false
+	}
+	parsed, _ := parseSSHArgs(args)
+	for _, forward := range parsed.LocalForwards {
+		if _, bindPort, ok := parseLocalForward(forward); ok && bindPort == port {
+			return true
+		}
+	}
+	return false
+}
+
+// describeHolders lists processes for messages
+func describeHolders(processes []PortProcess) string {
+	if len(processes) == 0 {
+		return "an unidentified process"
+	}
+	names := make([]string, len(processes))
+	for i, process := range processes {
+		names[i] = process.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// activePort is the local port the tunnel listens on: the configured port, or the
+// one chosen by next-free-port. It is only changed by the maintenance loop before
+// ssh starts.
+func (t *Tunnel) activePort() string {
+	if t.boundPort != "" {
+		return t.boundPort
+	}
+	return t.config.LocalPort
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// preconditionPollInterval is how often a waiting tunnel re-checks its preconditions
+// when no network change event arrives
+const preconditionPollInterval = 10 * time.Second
+
+// Precondition is a network condition that must hold before a tunnel connects,
+// such as a VPN interface being up
+type Precondition struct {
+	Type  string `json:"type"`  // "interface", "route" or "dns"
+	Value string `json:"value"` // Interface name, CIDR (or address) or hostname
+}
+
+// String describes the precondition for logs and status messages
+func (p Precondition) String() string {
+	switch p.Type {
+	case "interface":
+		return fmt.Sprintf("interface %s up", p.Value)
+	case "route":
+		return fmt.Sprintf("route to %s", p.Value)
+	case "dns":
+		return fmt.Sprintf("%s resolves", p.Value)
+	default:
+		return fmt.Sprintf("%s %s", p.Type, p.Value)
+	}
+}
+
+// validatePreconditions checks that every precondition is well formed
+func validatePreconditions(preconditions []Precondition) error {
+	for _, p := range preconditions {
+		if strings.TrimSpace(p.Value) == "" {
+			return fmt.Errorf("%s precondition requires a value", p.Type)
+		}
+		switch p.Type {
+		case "interface", "dns":
+		case "route":
+			if _, err := parseRouteTarget(p.Value); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown precondition type %q", p.Type)
+		}
+	}
+	return nil
+}
+
+// parseRouteTarget accepts a CIDR or a single address
+func parseRouteTarget(value string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(value); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("route precondition %q must be a CIDR or IP address", value)
+	}
+	bits := 128
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// CheckPrecondition evaluates a single precondition against the current network state
+func (nm *NetworkMonitor) CheckPrecondition(p Precondition) error {
+	switch p.Type {
+	case "interface":
+		iface, err := net.InterfaceByName(p.Value)
+		if err != nil {
+			return fmt.Errorf("interface %s not found", p.Value)
+		}
+		if iface.Flags&net.FlagUp == 0 {
+			return fmt.Errorf("interface %s is down", p.Value)
+		}
+		return nil
+
+	case "route":
+		network, err := parseRouteTarget(p.Value)
+		if err != nil {
+			return err
+		}
+		found, err := hasRouteTo(network)
+		if err != nil {
+			return fmt.Errorf("cannot check route to %s: %v", p.Value, err)
+		}
+		if !found {
+			return fmt.Errorf("no route to %s", p.Value)
+		}
+		return nil
+
+	case "dns":
+		ctx, cancel := context.WithTimeout(context.Background(), defaultNetworkProbeTimeout)
+		defer cancel()
+		if _, err := net.DefaultResolver.LookupHost(ctx, p.Value); err != nil {
+			return fmt.Errorf("%s does not resolve", p.Value)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown precondition type %q", p.Type)
+	}
+}
+
+// checkPreconditions returns the first unmet precondition of the tunnel, if any
+func (t *Tunnel) checkPreconditions() error {
+	if t.manager == nil {
+		return nil
+	}
+	for _, p := range t.config.Preconditions {
+		if err := t.manager.networkMonitor.CheckPrecondition(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForPreconditions parks the tunnel in the waiting state until its preconditions
+// hold. Network change events wake it immediately; a slow poll covers everything else.
+// It returns false if the loop was cancelled.
+func (t *Tunnel) waitForPreconditions(ctx context.Context, unmet error) bool {
+	t.mutex.Lock()
+	if ctx.Err() == nil {
+		t.setState(StateWaiting, "Waiting for precondition: "+unmet.Error())
+	}
+	t.mutex.Unlock()
+
+	ticker := time.NewTicker(preconditionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		case <-t.wake:
+		}
+
+		err := t.checkPreconditions()
+		if err == nil {
+			return true
+		}
+
+		// Keep the reason current when a different precondition is now the blocker
+		if err.Error() != unmet.Error() {
+			unmet = err
+			t.mutex.Lock()
+			if ctx.Err() == nil {
+				t.setState(StateWaiting, "Waiting for precondition: "+unmet.Error())
+			}
+			t.mutex.Unlock()
+		}
+	}
+}
+
+// enforcePreconditions restarts connected tunnels whose preconditions no longer hold,
+// so they drop their dead session and wait instead of sitting on it
+func (tm *TunnelManager) enforcePreconditions() {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	for _, tunnel := range tm.tunnels {
+		tunnel.mutex.RLock()
+		watched := tunnel.config.Enabled && len(tunnel.config.Preconditions) > 0
+		active := tunnel.status == StateConnected || tunnel.status == StateUnhealthy || tunnel.status == StateConnecting
+		tunnel.mutex.RUnlock()
+
+		if !watched || !active {
+			continue
+		}
+		if err := tunnel.checkPreconditions(); err != nil {
+			go tunnel.Restart("precondition lost: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Reverse proxy defaults
+const (
+	defaultProxyListen = "127.0.0.1:10080"
+	defaultProxyDomain = "localhost"
+)
+
+// ProxySettings configures the host-based HTTP reverse proxy that serves
+// http://<tunnel>.localhost:<port> for tunnels forwarding web applications
+type ProxySettings struct {
+	Enabled bool   `json:"enabled"`          // Run the reverse proxy
+	Listen  string `json:"listen,omitempty"` // Address the proxy listens on
+	Domain  string `json:"domain,omitempty"` // Host suffix routed to tunnels
+}
+
+// validate checks the proxy settings
+func (s ProxySettings) validate() error {
+	if s.Listen != "" {
+		if _, _, err := net.SplitHostPort(s.Listen); err != nil {
+			return fmt.Errorf("invalid listen address %q: %v", s.Listen, err)
+		}
+	}
+	if s.Domain != "" {
+		for _, label := range strings.Split(s.domain(), ".") {
+			if label == "" || dnsLabel(label) != label {
+				return fmt.Errorf("invalid domain %q", s.Domain)
+			}
+		}
+	}
+	return nil
+}
+
+// listen returns the proxy address, falling back to the default
+func (s ProxySettings) listen() string {
+	if s.Listen == "" {
+		return defaultProxyListen
+	}
+	return s.Listen
+}
+
+// domain returns the host suffix without dots, falling back to the default
+func (s ProxySettings) domain() string {
+	domain := strings.ToLower(strings.Trim(s.Domain, "."))
+	if domain == "" {
+		return defaultProxyDomain
+	}
+	return domain
+}
+
+// proxyURL returns the stable URL of a tunnel behind the proxy, or "" when the proxy is off
+func (tm *TunnelManager) proxyURL(tunnel *Tunnel) string {
+	proxy := tm.settings.Proxy
+	label := dnsLabel(tunnel.config.Name)
+	if !proxy.Enabled || label == "" {
+		return ""
+	}
+	_, port, _ := net.SplitHostPort(proxy.listen())
+	return fmt.Sprintf("http://%s.%s:%s/", label, proxy.domain(), port)
+}
+
+// tunnelForHost finds the tunnel addressed by a Host header such as grafana.localhost:10080
+func (tm *TunnelManager) tunnelForHost(host string) (*Tunnel, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	label := strings.TrimSuffix(host, "."+tm.settings.Proxy.domain())
+	if label == host || label == "" {
+		return nil, false
+	}
+
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	for _, tunnel := range tm.tunnels {
+		if dnsLabel(tunnel.config.Name) == label {
+			return tunnel, true
+		}
+	}
+	return nil, false
+}
+
+// startProxy runs the reverse proxy until ctx is cancelled
+func (tm *TunnelManager) startProxy(ctx context.Context) error {
+	listener, err := net.Listen("tcp", tm.settings.Proxy.listen())
+	if err != nil {
+		return fmt.Errorf("proxy listen on %s: %v", tm.settings.Proxy.listen(), err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(tm.serveProxy)}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Reverse proxy stopped: %v", err)
+		}
+	}()
+
+	log.Printf("🌐 Reverse proxy for http://<tunnel>.%s listening on %s", tm.settings.Proxy.domain(), tm.settings.Proxy.listen())
+	return nil
+}
+
+// serveProxy routes a request to the tunnel named in its Host header. WebSocket
+// upgrades are passed through by httputil.ReverseProxy.
+func (tm *TunnelManager) serveProxy(w http.ResponseWriter, r *http.Request) {
+	tunnel, ok := tm.tunnelForHost(r.Host)
+	if !ok {
+		tm.serveProxyIndex(w, r)
+		return
+	}
+
+	tunnel.mutex.RLock()
+	status := tunnel.status
+	ready := tunnel.canProxy()
+	target := &url.URL{Scheme: "http", Host: tunnel.dialAddress()}
+	tunnel.mutex.RUnlock()
+
+	if !ready {
+		http.Error(w, fmt.Sprintf("Tunnel '%s' is %s", tunnel.config.Name, status), http.StatusBadGateway)
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Proxy error for tunnel '%s': %v", tunnel.config.Name, err)
+			http.Error(w, fmt.Sprintf("Tunnel '%s' is not responding: %v", tunnel.config.Name, err), http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// canProxy reports whether requests can be passed to the tunnel's local address: it
+// is up, or its managed listener starts an idle tunnel or holds them while ssh
+// reconnects. The caller must hold t.mutex.
+func (t *Tunnel) canProxy() bool {
+	switch t.status {
+	case StateConnected, StateUnhealthy:
+		return true
+	case StateConnecting, StateError:
+		return t.listener != nil && t.holdTimeout() > 0
+	case StateIdle:
+		return t.listener != nil
+	}
+	return false
+}
+
+// serveProxyIndex lists the proxied tunnels for requests that name no tunnel
+func (tm *TunnelManager) serveProxyIndex(w http.ResponseWriter, r *http.Request) {
+	tm.mutex.RLock()
+	var links []string
+	for _, tunnel := range tm.tunnels {
+		if link := tm.proxyURL(tunnel); link != "" {
+			links = append(links, fmt.Sprintf(`<li><a href="%s">%s</a></li>`, html.EscapeString(link), html.EscapeString(link)))
+		}
+	}
+	tm.mutex.RUnlock()
+	sort.Strings(links)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintf(w, "<h1>No tunnel for %s</h1><ul>%s</ul>", html.EscapeString(r.Host), strings.Join(links, ""))
+}
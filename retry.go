@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Built-in retry defaults, used when neither the tunnel nor the global settings set a value
+const (
+	defaultRetryInitial    = 5 * time.Second
+	defaultRetryMultiplier = 2.0
+	defaultRetryMax        = 60 * time.Second
+	defaultRetryResetAfter = 30 * time.Second
+)
+
+// RetryPolicy controls how a tunnel backs off between connection attempts.
+// Zero values inherit from the global policy and then from the built-in defaults.
+// A negative Jitter or MaxAttempts explicitly disables jitter or the attempt limit.
+type RetryPolicy struct {
+	Initial     Duration `json:"initial,omitempty"`     // First retry delay (default 5s)
+	Multiplier  float64  `json:"multiplier,omitempty"`  // Delay growth factor per failed attempt (default 2)
+	Max         Duration `json:"max,omitempty"`         // Upper bound on the delay (default 60s)
+	Jitter      float64  `json:"jitter,omitempty"`      // Random spread as a fraction of the delay, 0-1 (default none)
+	MaxAttempts int      `json:"maxAttempts,omitempty"` // Attempts before giving up (default unlimited)
+	ResetAfter  Duration `json:"resetAfter,omitempty"`  // Connected time after which the attempt counter resets (default 30s)
+}
+
+// validate checks that the policy values make sense
+func (p RetryPolicy) validate() error {
+	if p.Initial < 0 || p.Max < 0 || p.ResetAfter < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+	if p.Multiplier != 0 && p.Multiplier < 1 {
+		return fmt.Errorf("multiplier must be at least 1")
+	}
+	if p.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+	if p.Initial > 0 && p.Max > 0 && p.Initial > p.Max {
+		return fmt.Errorf("initial delay must not exceed max delay")
+	}
+	return nil
+}
+
+// withDefaults fills every unset field of p from fallback
+func (p RetryPolicy) withDefaults(fallback RetryPolicy) RetryPolicy {
+	if p.Initial == 0 {
+		p.Initial = fallback.Initial
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = fallback.Multiplier
+	}
+	if p.Max == 0 {
+		p.Max = fallback.Max
+	}
+	if p.Jitter == 0 {
+		p.Jitter = fallback.Jitter
+	}
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = fallback.MaxAttempts
+	}
+	if p.ResetAfter == 0 {
+		p.ResetAfter = fallback.ResetAfter
+	}
+	return p
+}
+
+// defaultRetryPolicy returns the built-in policy
+func defaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Initial:    Duration(defaultRetryInitial),
+		Multiplier: defaultRetryMultiplier,
+		Max:        Duration(defaultRetryMax),
+		ResetAfter: Duration(defaultRetryResetAfter),
+	}
+}
+
+// backoff tracks the retry state of a single maintenance loop
+type backoff struct {
+	policy  RetryPolicy
+	attempt int
+	delay   time.Duration
+}
+
+// newBackoff creates a backoff for a fully resolved policy
+func newBackoff(policy RetryPolicy) *backoff {
+	b := &backoff{policy: policy}
+	b.reset()
+	return b
+}
+
+// reset starts over from the initial delay with a fresh attempt counter
+func (b *backoff) reset() {
+	b.attempt = 0
+	b.delay = time.Duration(b.policy.Initial)
+}
+
+// exhausted reports whether the attempt limit has been reached
+func (b *backoff) exhausted() bool {
+	return b.policy.MaxAttempts > 0 && b.attempt >= b.policy.MaxAttempts
+}
+
+// next records a failed attempt and returns how long to wait before the next one
+func (b *backoff) next() time.Duration {
+	b.attempt++
+
+	wait := b.delay
+	if b.policy.Jitter > 0 {
+		spread := float64(wait) * b.policy.Jitter
+		wait += time.Duration((rand.Float64()*2 - 1) * spread)
+	}
+
+	b.delay = time.Duration(float64(b.delay) * b.policy.Multiplier)
+	if max := time.Duration(b.policy.Max); max > 0 && b.delay > max {
+		b.delay = max
+	}
+
+	return wait
+}
@@ -0,0 +1,205 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Route flags from <linux/route.h>
+const (
+	rtfUp      = 0x1
+	rtfGateway = 0x2
+)
+
+// defaultGateway returns the gateway and interface of the default route, read from
+// /proc/net/route and falling back to /proc/net/ipv6_route
+func defaultGateway() (net.IP, string, error) {
+	gateway, iface, err := defaultGatewayIPv4()
+	if err == nil {
+		return gateway, iface, nil
+	}
+
+	gateway6, iface6, err6 := defaultGatewayIPv6()
+	if err6 == nil {
+		return gateway6, iface6, nil
+	}
+
+	return nil, "", err
+}
+
+// defaultGatewayIPv4 parses /proc/net/route, where addresses are little-endian hex
+func defaultGatewayIPv4() (net.IP, string, error) {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil || flags&rtfUp == 0 {
+			continue
+		}
+		// Destination and mask 0.0.0.0/0 is the default route
+		if fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+
+		if flags&rtfGateway == 0 {
+			return nil, fields[0], nil
+		}
+
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		gateway := make(net.IP, 4)
+		binary.LittleEndian.PutUint32(gateway, binary.BigEndian.Uint32(raw))
+		return gateway, fields[0], nil
+	}
+
+	return nil, "", fmt.Errorf("no IPv4 default route")
+}
+
+// defaultGatewayIPv6 parses /proc/net/ipv6_route
+func defaultGatewayIPv6() (net.IP, string, error) {
+	file, err := os.Open("/proc/net/ipv6_route")
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		// Default route: ::/0 with a usable route
+		if fields[0] != strings.Repeat("0", 32) || fields[1] != "00" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[8], 16, 32)
+		if err != nil || flags&rtfUp == 0 || fields[9] == "lo" {
+			continue
+		}
+
+		raw, err := hex.DecodeString(fields[4])
+		if err != nil || len(raw) != 16 {
+			continue
+		}
+		if flags&rtfGateway == 0 {
+			return nil, fields[9], nil
+		}
+		return net.IP(raw), fields[9], nil
+	}
+
+	return nil, "", fmt.Errorf("no IPv6 default route")
+}
+
+// hasRouteTo reports whether a non-default route in the main routing table covers the network
+func hasRouteTo(network *net.IPNet) (bool, error) {
+	if network.IP.To4() != nil {
+		return hasIPv4RouteTo(network)
+	}
+	return hasIPv6RouteTo(network)
+}
+
+// hasIPv4RouteTo scans /proc/net/route for a route covering network
+func hasIPv4RouteTo(network *net.IPNet) (bool, error) {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	wantOnes, _ := network.Mask.Size()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil || flags&rtfUp == 0 {
+			continue
+		}
+
+		dst, errDst := hex.DecodeString(fields[1])
+		mask, errMask := hex.DecodeString(fields[7])
+		if errDst != nil || errMask != nil || len(dst) != 4 || len(mask) != 4 {
+			continue
+		}
+
+		route := &net.IPNet{
+			IP:   net.IPv4(dst[3], dst[2], dst[1], dst[0]).To4(),
+			Mask: net.IPv4Mask(mask[3], mask[2], mask[1], mask[0]),
+		}
+		ones, _ := route.Mask.Size()
+		if ones == 0 {
+			continue // the default route covers everything and proves nothing
+		}
+		if ones <= wantOnes && route.Contains(network.IP) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// hasIPv6RouteTo scans /proc/net/ipv6_route for a route covering network
+func hasIPv6RouteTo(network *net.IPNet) (bool, error) {
+	file, err := os.Open("/proc/net/ipv6_route")
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	wantOnes, _ := network.Mask.Size()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		flags, err := strconv.ParseUint(fields[8], 16, 32)
+		if err != nil || flags&rtfUp == 0 || fields[9] == "lo" {
+			continue
+		}
+
+		dst, err := hex.DecodeString(fields[0])
+		if err != nil || len(dst) != 16 {
+			continue
+		}
+		ones, err := strconv.ParseUint(fields[1], 16, 8)
+		if err != nil || ones == 0 {
+			continue
+		}
+
+		route := &net.IPNet{IP: net.IP(dst), Mask: net.CIDRMask(int(ones), 128)}
+		if int(ones) <= wantOnes && route.Contains(network.IP) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,68 @@
+//go:build !linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// defaultGateway returns the gateway and interface of the default route using the
+// BSD route(8) command, which is available on macOS and the BSDs
+func defaultGateway() (net.IP, string, error) {
+	if runtime.GOOS == "windows" {
+		return nil, "", errRoutesUnsupported
+	}
+
+	output, err := exec.Command("route", "-n", "get", "default").Output()
+	if err != nil {
+		return nil, "", fmt.Errorf("no default route: %v", err)
+	}
+
+	var gateway net.IP
+	iface := ""
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "gateway":
+			gateway = net.ParseIP(strings.TrimSpace(value))
+		case "interface":
+			iface = strings.TrimSpace(value)
+		}
+	}
+
+	if iface == "" {
+		return nil, "", fmt.Errorf("no default route")
+	}
+	return gateway, iface, nil
+}
+
+// hasRouteTo reports whether a route other than the default route covers the network
+func hasRouteTo(network *net.IPNet) (bool, error) {
+	if runtime.GOOS == "windows" {
+		return false, errRoutesUnsupported
+	}
+
+	output, err := exec.Command("route", "-n", "get", network.IP.String()).Output()
+	if err != nil {
+		return false, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if found && strings.TrimSpace(key) == "destination" {
+			return strings.TrimSpace(value) != "default", nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleCheckInterval is how often the scheduler looks for window boundaries
+const scheduleCheckInterval = 10 * time.Second
+
+// scheduleHorizon bounds how far ahead and back schedules are searched
+const scheduleHorizon = 366 * 24 * time.Hour
+
+// Schedule restricts when a tunnel runs, either as weekly windows or as a pair of
+// cron expressions that start and stop it
+type Schedule struct {
+	Timezone string           `json:"timezone,omitempty"` // IANA zone such as Europe/Berlin; local time if empty
+	Windows  []ScheduleWindow `json:"windows,omitempty"`  // Weekly windows during which the tunnel runs
+	Start    string           `json:"start,omitempty"`    // Cron expression that starts the tunnel
+	Stop     string           `json:"stop,omitempty"`     // Cron expression that stops it
+}
+
+// ScheduleWindow is a weekly time range, such as mon-fri 09:00-18:00. A window whose
+// end is not after its start runs past midnight into the next day.
+type ScheduleWindow struct {
+	Days []string `json:"days"` // Day names or ranges: "mon", "mon-fri", "sat-sun"
+	From string   `json:"from"` // Start time, "HH:MM"
+	To   string   `json:"to"`   // End time, "HH:MM"; "24:00" is midnight at the end of the day
+}
+
+// validate checks the timezone, windows and cron expressions
+func (s *Schedule) validate() error {
+	if _, err := s.location(); err != nil {
+		return err
+	}
+
+	switch {
+	case len(s.Windows) > 0 && (s.Start != "" || s.Stop != ""):
+		return fmt.Errorf("use either windows or start/stop cron expressions, not both")
+	case len(s.Windows) == 0 && s.Start == "":
+		return fmt.Errorf("needs windows or a start cron expression")
+	}
+
+	for i, window := range s.Windows {
+		if _, _, _, err := window.parse(); err != nil {
+			return fmt.Errorf("window %d: %v", i+1, err)
+		}
+	}
+	if s.Start != "" {
+		if _, err := parseCron(s.Start); err != nil {
+			return fmt.Errorf("start: %v", err)
+		}
+	}
+	if s.Stop != "" {
+		if _, err := parseCron(s.Stop); err != nil {
+			return fmt.Errorf("stop: %v", err)
+		}
+	}
+	return nil
+}
+
+// location loads the schedule's timezone
+func (s *Schedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", s.Timezone)
+	}
+	return loc, nil
+}
+
+// state reports whether the schedule allows running at now, and when that next
+// changes. A zero time means it never changes within a year.
+func (s *Schedule) state(now time.Time) (bool, time.Time) {
+	loc, err := s.location()
+	if err != nil {
+		return false, time.Time{}
+	}
+	now = now.In(loc)
+	if len(s.Windows) > 0 {
+		return s.windowState(now)
+	}
+	return s.cronState(now)
+}
+
+// windowState finds the merged window around now and the next boundary
+func (s *Schedule) windowState(now time.Time) (bool, time.Time) {
+	type interval struct{ start, end time.Time }
+
+	// Collect every window occurrence from yesterday (for windows running past
+	// midnight) to a week and a day ahead, then merge touching ones
+	var intervals []interval
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for _, window := range s.Windows {
+		days, from, to, err := window.parse()
+		if err != nil {
+			continue
+		}
+		for offset := -1; offset <= 8; offset++ {
+			date := today.AddDate(0, 0, offset)
+			if !days[date.Weekday()] {
+				continue
+			}
+			endDay := date.Day()
+			if to <= from {
+				endDay++
+			}
+			// time.Date normalizes the minutes, which keeps windows right across DST changes
+			intervals = append(intervals, interval{
+				start: time.Date(date.Year(), date.Month(), date.Day(), 0, from, 0, 0, date.Location()),
+				end:   time.Date(date.Year(), date.Month(), endDay, 0, to, 0, 0, date.Location()),
+			})
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].start.Before(intervals[j].start) })
+
+	var merged []interval
+	for _, iv := range intervals {
+		if n := len(merged); n > 0 && !iv.start.After(merged[n-1].end) {
+			if iv.end.After(merged[n-1].end) {
+				merged[n-1].end = iv.end
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+
+	horizon := today.AddDate(0, 0, 8)
+	for _, iv := range merged {
+		if iv.end.Before(now) || iv.end.Equal(now) {
+			continue
+		}
+		if iv.start.After(now) {
+			return false, iv.start
+		}
+		if !iv.end.Before(horizon) {
+			return true, time.Time{} // Always on
+		}
+		return true, iv.end
+	}
+	return false, time.Time{}
+}
+
+// cronState compares the latest start and stop firings: the tunnel runs if it was
+// started more recently than it was stopped
+func (s *Schedule) cronState(now time.Time) (bool, time.Time) {
+	start, err := parseCron(s.Start)
+	if err != nil {
+		return false, time.Time{}
+	}
+	var stop *cronSchedule
+	if s.Stop != "" {
+		if stop, err = parseCron(s.Stop); err != nil {
+			return false, time.Time{}
+		}
+	}
+
+	lastStart := start.prev(now)
+	var lastStop time.Time
+	if stop != nil {
+		lastStop = stop.prev(now)
+	}
+	active := !lastStart.IsZero() && lastStart.After(lastStop)
+
+	if active {
+		if stop == nil {
+			return true, time.Time{}
+		}
+		return true, stop.next(now)
+	}
+	return false, start.next(now)
+}
+
+// dayNames maps day names to weekdays, for windows and cron expressions
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parse returns the window's weekdays and its start and end in minutes after midnight
+func (w ScheduleWindow) parse() (map[time.Weekday]bool, int, int, error) {
+	if len(w.Days) == 0 {
+		return nil, 0, 0, fmt.Errorf("no days")
+	}
+	days := make(map[time.Weekday]bool)
+	for _, spec := range w.Days {
+		spec = strings.ToLower(strings.TrimSpace(spec))
+		first, last, isRange := strings.Cut(spec, "-")
+		from, ok := dayNames[first[:min(3, len(first))]]
+		if !ok {
+			return nil, 0, 0, fmt.Errorf("unknown day %q", spec)
+		}
+		to := from
+		if isRange {
+			if to, ok = dayNames[last[:min(3, len(last))]]; !ok {
+				return nil, 0, 0, fmt.Errorf("unknown day %q", spec)
+			}
+		}
+		// Ranges wrap around the week, so fri-mon is Friday to Monday
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+
+	from, err := parseClock(w.From)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("from: %v", err)
+	}
+	to, err := parseClock(w.To)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("to: %v", err)
+	}
+	if from == 24*60 {
+		return nil, 0, 0, fmt.Errorf("from must be before 24:00")
+	}
+	return days, from, to, nil
+}
+
+// parseClock parses "HH:MM" into minutes after midnight, allowing "24:00"
+func parseClock(value string) (int, error) {
+	hour, minute, ok := strings.Cut(value, ":")
+	h, err1 := strconv.Atoi(hour)
+	m, err2 := strconv.Atoi(minute)
+	if !ok || err1 != nil || err2 != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", value)
+	}
+	return h*60 + m, nil
+}
+
+// cronSchedule is a parsed five-field cron expression: minute hour day-of-month month day-of-week
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // Bit sets of allowed values
+	domAny, dowAny                bool   // Field was "*", for the day matching rule
+}
+
+// cronMacros are the usual shorthand expressions
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// monthNames maps month abbreviations for cron expressions
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+// parseCron parses a standard five-field cron expression or macro
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: want 5 fields", expr)
+	}
+
+	weekdays := make(map[string]int, len(dayNames))
+	for name, day := range dayNames {
+		weekdays[name] = int(day)
+	}
+
+	var c cronSchedule
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %v", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, weekdays); err != nil {
+		return nil, fmt.Errorf("day of week: %v", err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 // 7 is Sunday too
+	}
+	c.domAny = fields[2] == "*" || fields[2] == "?"
+	c.dowAny = fields[4] == "*" || fields[4] == "?"
+	return &c, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps
+func parseCronField(field string, low, high int, names map[string]int) (uint64, error) {
+	value := func(s string) (int, error) {
+		if n, ok := names[strings.ToLower(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < low || n > high {
+			return 0, fmt.Errorf("invalid value %q", s)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		spec, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+
+		first, last := low, high
+		switch {
+		case spec == "*" || spec == "?":
+		case strings.Contains(spec, "-"):
+			from, to, _ := strings.Cut(spec, "-")
+			var err error
+			if first, err = value(from); err != nil {
+				return 0, err
+			}
+			if last, err = value(to); err != nil {
+				return 0, err
+			}
+			if first > last {
+				return 0, fmt.Errorf("invalid range %q", spec)
+			}
+		default:
+			n, err := value(spec)
+			if err != nil {
+				return 0, err
+			}
+			first, last = n, n
+			if hasStep {
+				last = high
+			}
+		}
+
+		for n := first; n <= last; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+// matchesDay applies cron's rule that a restricted day of month and day of week match
+// if either does
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first firing strictly after t, or a zero time within the horizon.
+// Times skipped by a DST change never fire; repeated ones fire on both passes.
+func (c *cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(scheduleHorizon)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			// Step in elapsed minutes: time.Date may pick either pass of a repeated hour
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// prev returns the latest firing at or before t, or a zero time within the horizon
+func (c *cronSchedule) prev(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute)
+	limit := t.Add(-scheduleHorizon)
+
+	for t.After(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc).Add(-time.Minute)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc).Add(-time.Minute)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Add(-time.Duration(t.Minute()+1) * time.Minute)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(-time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// scheduleState reports whether the tunnel's schedule allows it to run at now, and
+// the next change. Tunnels without a schedule may always run.
+func (t *Tunnel) scheduleState(now time.Time) (bool, time.Time) {
+	if t.config.Schedule == nil {
+		return true, time.Time{}
+	}
+	return t.config.Schedule.state(now)
+}
+
+// runScheduler starts and stops enabled tunnels at their schedule boundaries
+func (tm *TunnelManager) runScheduler(ctx context.Context) {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+
+	// Whether each tunnel's schedule was active at the previous check
+	wasActive := make(map[*Tunnel]bool)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		seen := make(map[*Tunnel]bool)
+
+		tm.mutex.RLock()
+		for _, tunnel := range tm.tunnels {
+			tunnel.mutex.RLock()
+			scheduled := tunnel.config.Schedule != nil && tunnel.config.Enabled
+			running := tunnel.cancel != nil
+			active, next := tunnel.scheduleState(now)
+			tunnel.mutex.RUnlock()
+
+			if !scheduled {
+				continue
+			}
+			seen[tunnel] = true
+
+			previous, known := wasActive[tunnel]
+			if !known {
+				previous = running
+			}
+			wasActive[tunnel] = active
+
+			switch {
+			case active && !previous:
+				log.Printf("Tunnel '%s' schedule window opened", tunnel.config.Name)
+				go tunnel.Start()
+			case !active && previous && running:
+				log.Printf("Tunnel '%s' schedule window closed", tunnel.config.Name)
+				go tunnel.StopWithReason(scheduleReason(next))
+			}
+		}
+		tm.mutex.RUnlock()
+
+		for tunnel := range wasActive {
+			if !seen[tunnel] {
+				delete(wasActive, tunnel)
+			}
+		}
+	}
+}
+
+// scheduleReason describes a tunnel held back by its schedule
+func scheduleReason(next time.Time) string {
+	if next.IsZero() {
+		return "outside schedule"
+	}
+	return "outside schedule, next start " + next.Format("Mon 15:04 MST")
+}
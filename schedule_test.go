@@ -0,0 +1,358 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// loadZone returns a timezone for the tests, skipping them without zoneinfo
+func loadZone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("timezone %s not available: %v", name, err)
+	}
+	return loc
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"*/15 * * * *", false},
+		{"0 9-17 * * mon-fri", false},
+		{"0,30 8 1,15 jan-jun *", false},
+		{"0 0 * * 7", false},
+		{"5/10 * * * *", false},
+		{"@yearly", false},
+		{"@Daily", false},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"5-1 * * * *", true},
+		{"*/0 * * * *", true},
+		{"a * * * *", true},
+		{"@never", true},
+	}
+	for _, tt := range tests {
+		_, err := parseCron(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCron(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseCronFields(t *testing.T) {
+	c, err := parseCron("*/20 9-11 * * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := uint64(1<<0 | 1<<20 | 1<<40); c.minute != want {
+		t.Errorf("minute = %b, want %b", c.minute, want)
+	}
+	if want := uint64(1<<9 | 1<<10 | 1<<11); c.hour != want {
+		t.Errorf("hour = %b, want %b", c.hour, want)
+	}
+	if c.dow&1 == 0 {
+		t.Errorf("day of week 7 does not include Sunday")
+	}
+	if !c.domAny || c.dowAny {
+		t.Errorf("domAny = %v, dowAny = %v, want true, false", c.domAny, c.dowAny)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	utc := time.UTC
+	berlin := loadZone(t, "Europe/Berlin")
+	kolkata := loadZone(t, "Asia/Kolkata")
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute is strictly after", "* * * * *",
+			time.Date(2026, 5, 4, 10, 0, 0, 0, utc), time.Date(2026, 5, 4, 10, 1, 0, 0, utc)},
+		{"seconds are ignored", "* * * * *",
+			time.Date(2026, 5, 4, 10, 0, 30, 0, utc), time.Date(2026, 5, 4, 10, 1, 0, 0, utc)},
+		{"later today", "30 18 * * *",
+			time.Date(2026, 5, 4, 10, 0, 0, 0, utc), time.Date(2026, 5, 4, 18, 30, 0, 0, utc)},
+		{"tomorrow", "30 8 * * *",
+			time.Date(2026, 5, 4, 10, 0, 0, 0, utc), time.Date(2026, 5, 5, 8, 30, 0, 0, utc)},
+		{"weekdays skip the weekend", "0 9 * * mon-fri",
+			time.Date(2026, 5, 8, 10, 0, 0, 0, utc), time.Date(2026, 5, 11, 9, 0, 0, 0, utc)},
+		{"end of month", "0 0 1 * *",
+			time.Date(2026, 1, 31, 23, 59, 0, 0, utc), time.Date(2026, 2, 1, 0, 0, 0, 0, utc)},
+		{"day of month or day of week", "0 0 13 * fri",
+			time.Date(2026, 11, 1, 0, 0, 0, 0, utc), time.Date(2026, 11, 6, 0, 0, 0, 0, utc)},
+		{"day of month alone", "0 0 13 * *",
+			time.Date(2026, 11, 1, 0, 0, 0, 0, utc), time.Date(2026, 11, 13, 0, 0, 0, 0, utc)},
+		{"day of week with any day of month", "0 0 * * fri",
+			time.Date(2026, 11, 7, 0, 0, 0, 0, utc), time.Date(2026, 11, 13, 0, 0, 0, 0, utc)},
+		{"february 29", "0 0 29 2 *",
+			time.Date(2027, 3, 1, 0, 0, 0, 0, utc), time.Date(2028, 2, 29, 0, 0, 0, 0, utc)},
+		{"yearly a full leap year ahead", "@yearly",
+			time.Date(2028, 1, 1, 0, 0, 0, 0, utc), time.Date(2029, 1, 1, 0, 0, 0, 0, utc)},
+		{"yearly across a DST year", "@yearly",
+			time.Date(2028, 1, 1, 0, 0, 0, 0, berlin), time.Date(2029, 1, 1, 0, 0, 0, 0, berlin)},
+		{"never within the horizon", "0 0 31 2 *",
+			time.Date(2026, 1, 1, 0, 0, 0, 0, utc), time.Time{}},
+		{"half-hour zone", "0 9 * * *",
+			time.Date(2026, 5, 4, 8, 59, 0, 0, kolkata), time.Date(2026, 5, 4, 9, 0, 0, 0, kolkata)},
+		{"skipped hour at spring forward", "30 2 * * *",
+			time.Date(2026, 3, 28, 12, 0, 0, 0, berlin), time.Date(2026, 3, 30, 2, 30, 0, 0, berlin)},
+		{"hour after spring forward", "0 3 * * *",
+			time.Date(2026, 3, 29, 1, 0, 0, 0, berlin), time.Date(2026, 3, 29, 3, 0, 0, 0, berlin)},
+		{"hourly across spring forward", "0 * * * *",
+			time.Date(2026, 3, 29, 1, 30, 0, 0, berlin), time.Date(2026, 3, 29, 3, 0, 0, 0, berlin)},
+		{"first pass of the repeated hour", "30 2 * * *",
+			time.Date(2026, 10, 24, 12, 0, 0, 0, berlin), time.Date(2026, 10, 25, 0, 30, 0, 0, utc)},
+		{"first pass of the repeated hour from the hour before", "30 2 * * *",
+			time.Date(2026, 10, 25, 1, 30, 0, 0, berlin), time.Date(2026, 10, 25, 0, 30, 0, 0, utc)},
+		{"second pass of the repeated hour", "30 2 * * *",
+			time.Date(2026, 10, 25, 0, 30, 0, 0, utc).In(berlin), time.Date(2026, 10, 25, 1, 30, 0, 0, utc)},
+		{"after the repeated hour", "30 2 * * *",
+			time.Date(2026, 10, 25, 1, 30, 0, 0, utc).In(berlin), time.Date(2026, 10, 26, 2, 30, 0, 0, berlin)},
+		{"hourly through the repeated hour", "0 * * * *",
+			time.Date(2026, 10, 25, 0, 0, 0, 0, utc).In(berlin), time.Date(2026, 10, 25, 1, 0, 0, 0, utc)},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := c.next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%s: next(%s) of %q = %s, want %s", tt.name, tt.from, tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCronPrev(t *testing.T) {
+	utc := time.UTC
+	berlin := loadZone(t, "Europe/Berlin")
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"includes the current minute", "0 9 * * *",
+			time.Date(2026, 5, 4, 9, 0, 45, 0, utc), time.Date(2026, 5, 4, 9, 0, 0, 0, utc)},
+		{"earlier today", "0 9 * * *",
+			time.Date(2026, 5, 4, 12, 0, 0, 0, utc), time.Date(2026, 5, 4, 9, 0, 0, 0, utc)},
+		{"yesterday", "0 18 * * *",
+			time.Date(2026, 5, 4, 12, 0, 0, 0, utc), time.Date(2026, 5, 3, 18, 0, 0, 0, utc)},
+		{"last friday", "0 17 * * fri",
+			time.Date(2026, 5, 11, 8, 0, 0, 0, utc), time.Date(2026, 5, 8, 17, 0, 0, 0, utc)},
+		{"previous month", "0 0 31 * *",
+			time.Date(2026, 5, 4, 0, 0, 0, 0, utc), time.Date(2026, 3, 31, 0, 0, 0, 0, utc)},
+		{"day of month or day of week", "0 0 1 * mon",
+			time.Date(2026, 11, 30, 12, 0, 0, 0, utc), time.Date(2026, 11, 30, 0, 0, 0, 0, utc)},
+		{"yearly at the end of a leap year", "@yearly",
+			time.Date(2028, 12, 31, 23, 59, 0, 0, berlin), time.Date(2028, 1, 1, 0, 0, 0, 0, berlin)},
+		{"never within the horizon", "0 0 30 2 *",
+			time.Date(2026, 5, 4, 0, 0, 0, 0, utc), time.Time{}},
+		{"skipped hour at spring forward", "30 2 * * *",
+			time.Date(2026, 3, 29, 12, 0, 0, 0, berlin), time.Date(2026, 3, 28, 2, 30, 0, 0, berlin)},
+		{"hourly across spring forward", "0 * * * *",
+			time.Date(2026, 3, 29, 3, 30, 0, 0, berlin), time.Date(2026, 3, 29, 3, 0, 0, 0, berlin)},
+		{"hour before spring forward", "0 * * * *",
+			time.Date(2026, 3, 29, 0, 59, 0, 0, utc).In(berlin), time.Date(2026, 3, 29, 0, 0, 0, 0, utc)},
+		{"second pass of the repeated hour", "30 2 * * *",
+			time.Date(2026, 10, 25, 2, 0, 0, 0, utc).In(berlin), time.Date(2026, 10, 25, 1, 30, 0, 0, utc)},
+		{"first pass of the repeated hour", "30 2 * * *",
+			time.Date(2026, 10, 25, 1, 0, 0, 0, utc).In(berlin), time.Date(2026, 10, 25, 0, 30, 0, 0, utc)},
+		{"hour before the repeated hour", "0 1 * * *",
+			time.Date(2026, 10, 25, 1, 30, 0, 0, utc).In(berlin), time.Date(2026, 10, 24, 23, 0, 0, 0, utc)},
+		{"hourly through the repeated hour", "0 * * * *",
+			time.Date(2026, 10, 25, 1, 30, 0, 0, utc).In(berlin), time.Date(2026, 10, 25, 1, 0, 0, 0, utc)},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := c.prev(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%s: prev(%s) of %q = %s, want %s", tt.name, tt.from, tt.expr, got, tt.want)
+		}
+	}
+}
+
+// walkCron returns the next count firings after from, in UTC, stepping forward with
+// next or backward with prev
+func walkCron(t *testing.T, expr string, from time.Time, count int, forward bool) []time.Time {
+	t.Helper()
+	c, err := parseCron(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var firings []time.Time
+	at := from
+	for i := 0; i < count; i++ {
+		if forward {
+			at = c.next(at)
+		} else {
+			at = c.prev(at)
+		}
+		if at.IsZero() {
+			break
+		}
+		firings = append(firings, at.UTC())
+		if !forward {
+			at = at.Add(-time.Minute)
+		}
+	}
+	return firings
+}
+
+// checkFirings compares walked firings with the expected UTC times
+func checkFirings(t *testing.T, name string, got []time.Time, want ...time.Time) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: %d firings %v, want %v", name, len(got), got, want)
+		return
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("%s: firing %d = %s, want %s", name, i, got[i], want[i])
+		}
+	}
+}
+
+func TestCronSpringForward(t *testing.T) {
+	// New York skips 02:00-02:59 on 8 March 2026: 01:59 EST (06:59Z) is followed by 03:00 EDT (07:00Z)
+	newYork := loadZone(t, "America/New_York")
+	utc := func(day, hour, minute int) time.Time { return time.Date(2026, 3, day, hour, minute, 0, 0, time.UTC) }
+
+	checkFirings(t, "every half hour",
+		walkCron(t, "*/30 * * * *", time.Date(2026, 3, 8, 0, 50, 0, 0, newYork), 5, true),
+		utc(8, 6, 0), utc(8, 6, 30), utc(8, 7, 0), utc(8, 7, 30), utc(8, 8, 0))
+
+	checkFirings(t, "daily in the skipped hour",
+		walkCron(t, "30 2 * * *", time.Date(2026, 3, 6, 12, 0, 0, 0, newYork), 3, true),
+		utc(7, 7, 30), utc(9, 6, 30), utc(10, 6, 30))
+
+	checkFirings(t, "daily after the skipped hour",
+		walkCron(t, "0 3 * * *", time.Date(2026, 3, 7, 12, 0, 0, 0, newYork), 2, true),
+		utc(8, 7, 0), utc(9, 7, 0))
+
+	checkFirings(t, "backward through the skipped hour",
+		walkCron(t, "30 2 * * *", time.Date(2026, 3, 10, 0, 0, 0, 0, newYork), 3, false),
+		utc(9, 6, 30), utc(7, 7, 30), utc(6, 7, 30))
+
+	// Between firings the walk never lands on a wall time that does not exist
+	for _, firing := range walkCron(t, "* * * * *", time.Date(2026, 3, 8, 1, 57, 0, 0, newYork), 6, true) {
+		if local := firing.In(newYork); local.Hour() == 2 {
+			t.Errorf("fired at %s, inside the skipped hour", local)
+		}
+	}
+}
+
+func TestCronFallBack(t *testing.T) {
+	// New York repeats 01:00-01:59 on 1 November 2026: first as EDT (05:00Z-05:59Z),
+	// then as EST (06:00Z-06:59Z)
+	newYork := loadZone(t, "America/New_York")
+	utc := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	checkFirings(t, "every half hour",
+		walkCron(t, "*/30 * * * *", time.Date(2026, 11, 1, 0, 50, 0, 0, newYork), 6, true),
+		utc(11, 1, 5, 0), utc(11, 1, 5, 30), utc(11, 1, 6, 0), utc(11, 1, 6, 30), utc(11, 1, 7, 0), utc(11, 1, 7, 30))
+
+	checkFirings(t, "daily in the repeated hour fires on both passes",
+		walkCron(t, "30 1 * * *", time.Date(2026, 10, 30, 12, 0, 0, 0, newYork), 4, true),
+		utc(10, 31, 5, 30), utc(11, 1, 5, 30), utc(11, 1, 6, 30), utc(11, 2, 6, 30))
+
+	checkFirings(t, "daily after the repeated hour fires once",
+		walkCron(t, "0 2 * * *", time.Date(2026, 10, 31, 12, 0, 0, 0, newYork), 2, true),
+		utc(11, 1, 7, 0), utc(11, 2, 7, 0))
+
+	checkFirings(t, "backward through the repeated hour",
+		walkCron(t, "30 1 * * *", time.Date(2026, 11, 2, 0, 0, 0, 0, newYork), 3, false),
+		utc(11, 1, 6, 30), utc(11, 1, 5, 30), utc(10, 31, 5, 30))
+
+	// Minute by minute, the repeated hour takes two real hours and nothing fires twice
+	firings := walkCron(t, "* * * * *", time.Date(2026, 11, 1, 0, 59, 0, 0, newYork), 121, true)
+	if len(firings) != 121 || !firings[120].Equal(utc(11, 1, 7, 0)) {
+		t.Errorf("every minute: %d firings ending %v, want 121 ending at 07:00Z", len(firings), firings[len(firings)-1])
+	}
+	for i := 1; i < len(firings); i++ {
+		if firings[i].Sub(firings[i-1]) != time.Minute {
+			t.Errorf("every minute: %s followed by %s", firings[i-1], firings[i])
+		}
+	}
+}
+
+func TestWindowState(t *testing.T) {
+	berlin := loadZone(t, "Europe/Berlin")
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, berlin)
+	}
+	window := func(from, to string, days ...string) ScheduleWindow {
+		return ScheduleWindow{Days: days, From: from, To: to}
+	}
+
+	// 2026-05-04 is a Monday
+	tests := []struct {
+		name       string
+		windows    []ScheduleWindow
+		now        time.Time
+		wantActive bool
+		wantNext   time.Time
+	}{
+		{"inside office hours", []ScheduleWindow{window("09:00", "18:00", "mon-fri")},
+			at(5, 6, 12, 0), true, at(5, 6, 18, 0)},
+		{"at the start", []ScheduleWindow{window("09:00", "18:00", "mon-fri")},
+			at(5, 6, 9, 0), true, at(5, 6, 18, 0)},
+		{"at the end", []ScheduleWindow{window("09:00", "18:00", "mon-fri")},
+			at(5, 6, 18, 0), false, at(5, 7, 9, 0)},
+		{"over the weekend", []ScheduleWindow{window("09:00", "18:00", "mon-fri")},
+			at(5, 8, 19, 0), false, at(5, 11, 9, 0)},
+		{"past midnight", []ScheduleWindow{window("22:00", "06:00", "fri")},
+			at(5, 9, 3, 0), true, at(5, 9, 6, 0)},
+		{"before an overnight window", []ScheduleWindow{window("22:00", "06:00", "fri")},
+			at(5, 8, 21, 0), false, at(5, 8, 22, 0)},
+		{"day range wrapping the week", []ScheduleWindow{window("10:00", "12:00", "fri-mon")},
+			at(5, 10, 11, 0), true, at(5, 10, 12, 0)},
+		{"outside a wrapping day range", []ScheduleWindow{window("10:00", "12:00", "fri-mon")},
+			at(5, 5, 11, 0), false, at(5, 8, 10, 0)},
+		{"until midnight", []ScheduleWindow{window("20:00", "24:00", "mon")},
+			at(5, 4, 23, 59), true, at(5, 5, 0, 0)},
+		{"whole days merge", []ScheduleWindow{window("00:00", "24:00", "mon", "tue")},
+			at(5, 4, 12, 0), true, at(5, 6, 0, 0)},
+		{"touching windows merge", []ScheduleWindow{window("22:00", "24:00", "mon"), window("00:00", "02:00", "tue")},
+			at(5, 4, 23, 0), true, at(5, 5, 2, 0)},
+		{"always on", []ScheduleWindow{window("00:00", "24:00", "mon-sun")},
+			at(5, 4, 12, 0), true, time.Time{}},
+		{"equal from and to is a full day", []ScheduleWindow{window("08:00", "08:00", "wed")},
+			at(5, 7, 7, 0), true, at(5, 7, 8, 0)},
+		{"spring forward inside a window", []ScheduleWindow{window("01:00", "04:00", "sun")},
+			at(3, 29, 1, 30), true, at(3, 29, 4, 0)},
+		{"day after spring forward", []ScheduleWindow{window("09:00", "17:00", "mon-fri")},
+			at(3, 27, 18, 0), false, at(3, 30, 9, 0)},
+		{"fall back inside a window", []ScheduleWindow{window("01:00", "04:00", "sun")},
+			time.Date(2026, 10, 25, 1, 30, 0, 0, time.UTC), true, at(10, 25, 4, 0)},
+		{"overnight window across fall back", []ScheduleWindow{window("22:00", "06:00", "sat")},
+			at(10, 24, 23, 0), true, at(10, 25, 6, 0)},
+	}
+	for _, tt := range tests {
+		s := &Schedule{Timezone: "Europe/Berlin", Windows: tt.windows}
+		if err := s.validate(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		active, next := s.state(tt.now)
+		if active != tt.wantActive || !next.Equal(tt.wantNext) {
+			t.Errorf("%s: state(%s) = %v, %s, want %v, %s", tt.name, tt.now, active, next, tt.wantActive, tt.wantNext)
+		}
+	}
+}
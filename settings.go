@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// Settings holds manager-wide options. They are read from settings.json next to
+// tunnels.json and apply to every tunnel unless the tunnel overrides them.
+type Settings struct {
+	Retry   RetryPolicy     `json:"retry"`
+	Network NetworkSettings `json:"network"`
+	DNS     DNSSettings     `json:"dns"`
+	Proxy   ProxySettings   `json:"proxy"`
+	Debug   bool            `json:"debug"` // Run every tunnel's ssh with -v
+}
+
+// loadSettings reads the settings file, falling back to defaults when it is missing or invalid
+func loadSettings(path string) Settings {
+	var settings Settings
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading settings file %s: %v", path, err)
+		}
+		return settings
+	}
+
+	if err := json.Unmarshal(data, &settings); err != nil {
+		log.Printf("Error parsing settings file %s: %v - using defaults", path, err)
+		return Settings{}
+	}
+
+	if err := settings.validate(); err != nil {
+		log.Printf("Invalid settings in %s: %v - using defaults", path, err)
+		return Settings{}
+	}
+
+	log.Printf("Loaded settings from %s", path)
+	return settings
+}
+
+// validate checks that the settings are usable
+func (s Settings) validate() error {
+	if err := s.Retry.validate(); err != nil {
+		return fmt.Errorf("retry: %v", err)
+	}
+	if err := s.Network.validate(); err != nil {
+		return fmt.Errorf("network: %v", err)
+	}
+	if err := s.DNS.validate(); err != nil {
+		return fmt.Errorf("dns: %v", err)
+	}
+	if err := s.Proxy.validate(); err != nil {
+		return fmt.Errorf("proxy: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sshFlagsWithValue are the ssh(1) option letters that take an argument
+const sshFlagsWithValue = "BbcDEeFIiJLlmOoPpQRSWw"
+
+// sshResolveTimeout bounds how long "ssh -G" may take to print its configuration
+const sshResolveTimeout = 3 * time.Second
+
+// maxSSHConfigDepth limits nested Include directives
+const maxSSHConfigDepth = 16
+
+// sshArgs is an ssh command line split into its options and destination
+type sshArgs struct {
+	Destination   string
+	Port          string            // -p
+	User          string            // -l
+	ConfigFile    string            // -F
+	JumpHost      string            // -J
+	Options       map[string]string // -o options, keyed by lower-case name; the first value wins
+	LocalForwards []string          // -L specifications
+	OptionArgs    []string          // Every option before the destination, as written
+}
+
+// parseSSHArgs walks an ssh argument list (including the leading "ssh") the way ssh
+// itself does, so values such as "-p 2222" or "-i key" are never mistaken for the host
+func parseSSHArgs(args []string) (sshArgs, error) {
+	parsed := sshArgs{Options: make(map[string]string)}
+	if len(args) == 0 {
+		return parsed, fmt.Errorf("empty command")
+	}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			if i+1 < len(args) {
+				parsed.Destination = args[i+1]
+			}
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			parsed.Destination = arg
+			break
+		}
+
+		start := i
+		// Flags may be grouped ("-NfL 8080:..."); only the last one in a group can take a value
+		for j := 1; j < len(arg); j++ {
+			flag := arg[j]
+			if !strings.ContainsRune(sshFlagsWithValue, rune(flag)) {
+				continue
+			}
+
+			value := arg[j+1:]
+			if value == "" {
+				if i+1 >= len(args) {
+					return parsed, fmt.Errorf("option -%c requires an argument", flag)
+				}
+				i++
+				value = args[i]
+			}
+			parsed.setFlag(flag, value)
+			break
+		}
+		parsed.OptionArgs = append(parsed.OptionArgs, args[start:i+1]...)
+	}
+
+	if parsed.Destination == "" {
+		return parsed, fmt.Errorf("no destination host in ssh command")
+	}
+	return parsed, nil
+}
+
+// setFlag records the value of a single ssh option
+func (a *sshArgs) setFlag(flag byte, value string) {
+	switch flag {
+	case 'p':
+		a.Port = value
+	case 'l':
+		a.User = value
+	case 'F':
+		a.ConfigFile = expandPath(value)
+	case 'J':
+		a.JumpHost = value
+	case 'L':
+		a.LocalForwards = append(a.LocalForwards, value)
+	case 'o':
+		key, val := splitSSHConfigLine(value)
+		key = strings.ToLower(key)
+		if _, exists := a.Options[key]; !exists && key != "" {
+			a.Options[key] = val
+		}
+	}
+}
+
+// parseLocalForward splits a -L specification, "[bind:]port:host:hostport" or
+// "[bind:]port:/remote/socket", into its bind address (as written) and local port
+func parseLocalForward(spec string) (bind, port string, ok bool) {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range spec {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				parts = append(parts, spec[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, spec[start:])
+
+	local := len(parts) - 3 // port:host:hostport
+	if strings.HasPrefix(parts[len(parts)-1], "/") {
+		local = len(parts) - 2 // port:/socket
+	}
+	switch local {
+	case 0:
+		port = parts[0]
+	case 1:
+		bind, port = parts[0], parts[1]
+	default:
+		return "", "", false
+	}
+
+	if _, err := strconv.Atoi(port); err != nil {
+		return "", "", false
+	}
+	return bind, port, true
+}
+
+// SSHEndpoint is where ssh actually connects once aliases and options are applied
+type SSHEndpoint struct {
+	Alias        string `json:"alias,omitempty"` // Destination as written, when it differs from Host
+	Host         string `json:"host"`
+	Port         string `json:"port"`
+	User         string `json:"user,omitempty"`
+	ProxyJump    string `json:"proxyJump,omitempty"`
+	ProxyCommand string `json:"proxyCommand,omitempty"`
+	Source       string `json:"source"` // "ssh -G" or "ssh_config"
+
+	configFile string // -F from the command, needed to resolve jump hosts the same way
+}
+
+// Address returns the host:port ssh dials
+func (e SSHEndpoint) Address() string {
+	return net.JoinHostPort(e.Host, e.Port)
+}
+
+// String describes the endpoint for logs
+func (e SSHEndpoint) String() string {
+	s := e.Address()
+	if e.User != "" {
+		s = e.User + "@" + s
+	}
+	if e.Alias != "" {
+		s = fmt.Sprintf("%s (%s)", s, e.Alias)
+	}
+	return s
+}
+
+// resolveSSHEndpoint works out the effective host and port of an ssh command. It asks
+// "ssh -G" first, which applies ssh_config exactly as ssh will, and falls back to
+// our own ssh_config reader when the ssh binary is missing or too old.
+func resolveSSHEndpoint(command string) (SSHEndpoint, error) {
+	args, err := parseSSHCommand(command)
+	if err != nil {
+		return SSHEndpoint{}, err
+	}
+	return resolveEndpointArgs(args)
+}
+
+// resolveEndpointArgs resolves the endpoint of an already split ssh command
+func resolveEndpointArgs(args []string) (SSHEndpoint, error) {
+	parsed, err := parseSSHArgs(args)
+	if err != nil {
+		return SSHEndpoint{}, err
+	}
+
+	endpoint, err := resolveWithSSH(parsed)
+	if err != nil {
+		if endpoint, err = resolveWithSSHConfig(parsed); err != nil {
+			return SSHEndpoint{}, err
+		}
+	}
+	endpoint.configFile = parsed.ConfigFile
+	return endpoint, nil
+}
+
+// resolveWithSSH runs "ssh -G", which prints the configuration ssh would use and exits
+func resolveWithSSH(parsed sshArgs) (SSHEndpoint, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sshResolveTimeout)
+	defer cancel()
+
+	args := append([]string{"-G"}, parsed.OptionArgs...)
+	args = append(args, parsed.Destination)
+
+	output, err := exec.CommandContext(ctx, "ssh", args...).Output()
+	if err != nil {
+		return SSHEndpoint{}, fmt.Errorf("ssh -G failed: %v", err)
+	}
+
+	values := parseSSHConfigDump(string(output))
+	if values["hostname"] == "" {
+		return SSHEndpoint{}, fmt.Errorf("ssh -G printed no hostname")
+	}
+
+	return newSSHEndpoint(parsed.Destination, values, "ssh -G"), nil
+}
+
+// parseSSHConfigDump reads the "keyword value" lines printed by "ssh -G", keyed by
+// lower-case keyword
+func parseSSHConfigDump(output string) map[string]string {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value := splitSSHConfigLine(scanner.Text())
+		if key != "" {
+			values[strings.ToLower(key)] = value
+		}
+	}
+	return values
+}
+
+// resolveWithSSHConfig applies command-line options and ssh_config ourselves. Only
+// Host blocks, "Match all" and Include are understood; other Match blocks are skipped.
+func resolveWithSSHConfig(parsed sshArgs) (SSHEndpoint, error) {
+	user, host, port := splitSSHDestination(parsed.Destination)
+	if host == "" {
+		return SSHEndpoint{}, fmt.Errorf("invalid destination %q", parsed.Destination)
+	}
+
+	// Command-line values take precedence over the config files
+	values := make(map[string]string)
+	for key, value := range parsed.Options {
+		values[key] = value
+	}
+	setDefault := func(key, value string) {
+		if _, exists := values[key]; !exists && value != "" {
+			values[key] = value
+		}
+	}
+	setDefault("port", parsed.Port)
+	setDefault("port", port)
+	setDefault("user", parsed.User)
+	setDefault("user", user)
+	setDefault("proxyjump", parsed.JumpHost)
+
+	if parsed.ConfigFile != "" {
+		if err := readSSHConfig(parsed.ConfigFile, host, values, 0); err != nil {
+			return SSHEndpoint{}, err
+		}
+	} else {
+		if home, err := os.UserHomeDir(); err == nil {
+			readSSHConfig(filepath.Join(home, ".ssh", "config"), host, values, 0)
+		}
+		readSSHConfig("/etc/ssh/ssh_config", host, values, 0)
+	}
+
+	hostname := values["hostname"]
+	if hostname == "" {
+		hostname = host
+	}
+	values["hostname"] = strings.NewReplacer("%h", host, "%%", "%").Replace(hostname)
+	setDefault("port", "22")
+
+	return newSSHEndpoint(host, values, "ssh_config"), nil
+}
+
+// newSSHEndpoint builds an endpoint from resolved ssh_config keywords
+func newSSHEndpoint(destination string, values map[string]string, source string) SSHEndpoint {
+	endpoint := SSHEndpoint{
+		Host:   values["hostname"],
+		Port:   values["port"],
+		User:   values["user"],
+		Source: source,
+	}
+	if endpoint.Port == "" {
+		endpoint.Port = "22"
+	}
+	if jump := values["proxyjump"]; jump != "" && jump != "none" {
+		endpoint.ProxyJump = jump
+	}
+	if proxy := values["proxycommand"]; proxy != "" && proxy != "none" {
+		endpoint.ProxyCommand = proxy
+	}
+
+	_, alias, _ := splitSSHDestination(destination)
+	if alias != "" && !strings.EqualFold(alias, endpoint.Host) {
+		endpoint.Alias = alias
+	}
+	return endpoint
+}
+
+// splitSSHDestination splits "[user@]host" or "ssh://[user@]host[:port]"
+func splitSSHDestination(destination string) (user, host, port string) {
+	if strings.HasPrefix(destination, "ssh://") {
+		destination = strings.TrimPrefix(destination, "ssh://")
+		destination = strings.TrimSuffix(destination, "/")
+		if at := strings.LastIndex(destination, "@"); at >= 0 {
+			user, destination = destination[:at], destination[at+1:]
+		}
+		if h, p, err := net.SplitHostPort(destination); err == nil {
+			return user, h, p
+		}
+		return user, strings.Trim(destination, "[]"), ""
+	}
+
+	if at := strings.LastIndex(destination, "@"); at >= 0 {
+		user, destination = destination[:at], destination[at+1:]
+	}
+	return user, destination, ""
+}
+
+// splitJumpHost parses the first hop of a ProxyJump value, "[user@]host[:port]"
+func splitJumpHost(jump string) (user, host, port string) {
+	first := strings.TrimSpace(strings.Split(jump, ",")[0])
+	if strings.HasPrefix(first, "ssh://") {
+		return splitSSHDestination(first)
+	}
+	if at := strings.LastIndex(first, "@"); at >= 0 {
+		user, first = first[:at], first[at+1:]
+	}
+	if h, p, err := net.SplitHostPort(first); err == nil {
+		return user, h, p
+	}
+	return user, strings.Trim(first, "[]"), ""
+}
+
+// readSSHConfig merges the settings that apply to host from one ssh_config file into
+// values. As in ssh, the first value found for a keyword wins.
+func readSSHConfig(file, host string, values map[string]string, depth int) error {
+	if depth > maxSSHConfigDepth {
+		return fmt.Errorf("ssh_config includes nested too deeply")
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Settings before the first Host or Match line apply to every host
+	active := true
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value := splitSSHConfigLine(scanner.Text())
+		if key == "" {
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "host":
+			active = matchSSHHostPatterns(host, strings.Fields(value))
+		case "match":
+			active = strings.EqualFold(strings.TrimSpace(value), "all")
+		case "include":
+			if !active {
+				continue
+			}
+			for _, pattern := range strings.Fields(value) {
+				pattern = expandPath(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(file), pattern)
+				}
+				matches, _ := filepath.Glob(pattern)
+				for _, match := range matches {
+					readSSHConfig(match, host, values, depth+1)
+				}
+			}
+		default:
+			if !active {
+				continue
+			}
+			lower := strings.ToLower(key)
+			if _, exists := values[lower]; !exists {
+				values[lower] = value
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// matchSSHHostPatterns implements ssh_config Host matching: at least one pattern
+// must match and no negated pattern may match
+func matchSSHHostPatterns(host string, patterns []string) bool {
+	host = strings.ToLower(host)
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.ToLower(strings.TrimPrefix(pattern, "!"))
+
+		if ok, _ := path.Match(pattern, host); ok {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// splitSSHConfigLine splits "Keyword value" or "Keyword=value", ignoring comments
+func splitSSHConfigLine(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", ""
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return line, ""
+	}
+	key := line[:end]
+	value := strings.TrimSpace(line[end:])
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+	return key, strings.Trim(value, `"`)
+}
+
+// probeAddress returns the address to dial to test whether the ssh server can be
+// reached: the first jump host for ProxyJump, or "" when a ProxyCommand makes the
+// real route unknowable
+func (e SSHEndpoint) probeAddress() (string, error) {
+	if e.ProxyCommand != "" {
+		return "", nil
+	}
+	if e.ProxyJump == "" {
+		return e.Address(), nil
+	}
+
+	user, host, port := splitJumpHost(e.ProxyJump)
+	if host == "" {
+		return "", fmt.Errorf("invalid ProxyJump %q", e.ProxyJump)
+	}
+
+	// The jump host may itself be an alias
+	jump := sshArgs{Destination: host, Port: port, User: user, ConfigFile: e.configFile, Options: map[string]string{}}
+	if port != "" {
+		jump.OptionArgs = append(jump.OptionArgs, "-p", port)
+	}
+	if e.configFile != "" {
+		jump.OptionArgs = append(jump.OptionArgs, "-F", e.configFile)
+	}
+
+	hop, err := resolveWithSSH(jump)
+	if err != nil {
+		if hop, err = resolveWithSSHConfig(jump); err != nil {
+			return "", err
+		}
+	}
+	// Only the first hop is probed; the rest of the chain is reached through it
+	if hop.ProxyCommand != "" || hop.ProxyJump != "" {
+		return "", nil
+	}
+	return hop.Address(), nil
+}
+
+// resolveEndpoint resolves the tunnel's active ssh endpoint and remembers it for status display
+func (t *Tunnel) resolveEndpoint() (SSHEndpoint, error) {
+	args, err := t.activeArgs()
+	var endpoint SSHEndpoint
+	if err == nil {
+		endpoint, err = resolveEndpointArgs(args)
+	}
+
+	t.mutex.Lock()
+	if err == nil {
+		t.endpoint = &endpoint
+	} else {
+		t.endpoint = nil
+	}
+	t.mutex.Unlock()
+
+	return endpoint, err
+}
+
+// endpointSuffix names the resolved endpoint for error messages; the caller must not hold the lock
+func (t *Tunnel) endpointSuffix() string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	if t.endpoint == nil {
+		return ""
+	}
+	return " (" + t.endpoint.Address() + ")"
+}
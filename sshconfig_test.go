@@ -0,0 +1,265 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSSHArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		destination string
+		port        string
+		user        string
+		jump        string
+		options     map[string]string
+		forwards    []string
+		optionArgs  []string
+		wantErr     bool
+	}{
+		{
+			name:        "plain host",
+			args:        []string{"ssh", "user@host"},
+			destination: "user@host",
+		},
+		{
+			name:        "values are not the host",
+			args:        []string{"ssh", "-p", "2222", "-i", "key", "-l", "me", "host", "uptime"},
+			destination: "host",
+			port:        "2222",
+			user:        "me",
+			optionArgs:  []string{"-p", "2222", "-i", "key", "-l", "me"},
+		},
+		{
+			name:        "attached values",
+			args:        []string{"ssh", "-p2222", "-Jbastion", "host"},
+			destination: "host",
+			port:        "2222",
+			jump:        "bastion",
+			optionArgs:  []string{"-p2222", "-Jbastion"},
+		},
+		{
+			name:        "grouped flags",
+			args:        []string{"ssh", "-NfL", "8080:localhost:80", "host"},
+			destination: "host",
+			forwards:    []string{"8080:localhost:80"},
+			optionArgs:  []string{"-NfL", "8080:localhost:80"},
+		},
+		{
+			name:        "grouped flags with attached value",
+			args:        []string{"ssh", "-NL5432:db:5432", "-TCv", "host"},
+			destination: "host",
+			forwards:    []string{"5432:db:5432"},
+			optionArgs:  []string{"-NL5432:db:5432", "-TCv"},
+		},
+		{
+			name:        "-o separated and attached",
+			args:        []string{"ssh", "-o", "Port 2200", "-oUser=admin", "-o", "port=2300", "host"},
+			destination: "host",
+			options:     map[string]string{"port": "2200", "user": "admin"},
+			optionArgs:  []string{"-o", "Port 2200", "-oUser=admin", "-o", "port=2300"},
+		},
+		{
+			name:        "bracketed IPv6 forward",
+			args:        []string{"ssh", "-L", "[::1]:8080:[fd00::2]:80", "-L", "9090:/run/app.sock", "host"},
+			destination: "host",
+			forwards:    []string{"[::1]:8080:[fd00::2]:80", "9090:/run/app.sock"},
+			optionArgs:  []string{"-L", "[::1]:8080:[fd00::2]:80", "-L", "9090:/run/app.sock"},
+		},
+		{
+			name:        "double dash",
+			args:        []string{"ssh", "-N", "--", "-host"},
+			destination: "-host",
+			optionArgs:  []string{"-N"},
+		},
+		{
+			name:    "empty command",
+			args:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "trailing flag without value",
+			args:    []string{"ssh", "-N", "-p"},
+			wantErr: true,
+		},
+		{
+			name:    "no destination",
+			args:    []string{"ssh", "-N", "-L", "8080:localhost:80"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		parsed, err := parseSSHArgs(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if tt.options == nil {
+			tt.options = map[string]string{}
+		}
+		if parsed.Destination != tt.destination || parsed.Port != tt.port || parsed.User != tt.user || parsed.JumpHost != tt.jump {
+			t.Errorf("%s: got destination %q port %q user %q jump %q", tt.name, parsed.Destination, parsed.Port, parsed.User, parsed.JumpHost)
+		}
+		if !reflect.DeepEqual(parsed.Options, tt.options) {
+			t.Errorf("%s: options = %v, want %v", tt.name, parsed.Options, tt.options)
+		}
+		if !reflect.DeepEqual(parsed.LocalForwards, tt.forwards) {
+			t.Errorf("%s: forwards = %q, want %q", tt.name, parsed.LocalForwards, tt.forwards)
+		}
+		if !reflect.DeepEqual(parsed.OptionArgs, tt.optionArgs) {
+			t.Errorf("%s: option args = %q, want %q", tt.name, parsed.OptionArgs, tt.optionArgs)
+		}
+	}
+}
+
+func TestParseLocalForward(t *testing.T) {
+	tests := []struct {
+		spec string
+		bind string
+		port string
+		ok   bool
+	}{
+		{"8080:localhost:80", "", "8080", true},
+		{"127.0.0.2:8080:localhost:80", "127.0.0.2", "8080", true},
+		{"localhost:8080:db:5432", "localhost", "8080", true},
+		{"*:8080:db:5432", "*", "8080", true},
+		{":8080:db:5432", "", "8080", true},
+		{"[::1]:8080:localhost:80", "[::1]", "8080", true},
+		{"8080:[fd00::2]:80", "", "8080", true},
+		{"[::1]:8080:[fd00::2]:80", "[::1]", "8080", true},
+		{"8080:/run/app.sock", "", "8080", true},
+		{"127.0.0.1:8080:/run/app.sock", "127.0.0.1", "8080", true},
+		{"http:localhost:80", "", "", false},
+		{"localhost:80", "", "", false},
+		{"a:b:8080:host:80", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		bind, port, ok := parseLocalForward(tt.spec)
+		if bind != tt.bind || port != tt.port || ok != tt.ok {
+			t.Errorf("parseLocalForward(%q) = %q, %q, %v, want %q, %q, %v", tt.spec, bind, port, ok, tt.bind, tt.port, tt.ok)
+		}
+	}
+}
+
+func TestParseSSHConfigDump(t *testing.T) {
+	output := "user deploy\nhostname 10.0.0.5\nport 2222\nproxyjump none\n" +
+		"proxycommand ssh -W %h:%p gateway\nidentityfile ~/.ssh/id_ed25519\n\n"
+	values := parseSSHConfigDump(output)
+
+	want := map[string]string{
+		"user":         "deploy",
+		"hostname":     "10.0.0.5",
+		"port":         "2222",
+		"proxyjump":    "none",
+		"proxycommand": "ssh -W %h:%p gateway",
+		"identityfile": "~/.ssh/id_ed25519",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("parseSSHConfigDump() = %v, want %v", values, want)
+	}
+
+	endpoint := newSSHEndpoint("prod", values, "ssh -G")
+	if endpoint.Address() != "10.0.0.5:2222" || endpoint.User != "deploy" || endpoint.Alias != "prod" ||
+		endpoint.ProxyJump != "" || endpoint.ProxyCommand != "ssh -W %h:%p gateway" {
+		t.Errorf("newSSHEndpoint() = %+v", endpoint)
+	}
+}
+
+func TestReadSSHConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	write("extra.conf", "Host db\n  User postgres\n")
+	config := write("config", `# Global settings come first
+ServerAliveInterval 30
+Include extra.conf
+
+Host prod prod-*
+    HostName=10.0.0.5
+    Port 2222
+
+Host *.internal !secret.internal
+    ProxyJump "bastion"
+
+Match exec "true"
+    User ignored
+
+Match all
+    User fallback
+
+Host *
+    Port 22
+`)
+
+	tests := []struct {
+		host string
+		want map[string]string
+	}{
+		{"prod", map[string]string{"serveraliveinterval": "30", "hostname": "10.0.0.5", "port": "2222", "user": "fallback"}},
+		{"prod-eu", map[string]string{"serveraliveinterval": "30", "hostname": "10.0.0.5", "port": "2222", "user": "fallback"}},
+		{"app.internal", map[string]string{"serveraliveinterval": "30", "proxyjump": "bastion", "user": "fallback", "port": "22"}},
+		{"secret.internal", map[string]string{"serveraliveinterval": "30", "user": "fallback", "port": "22"}},
+		{"db", map[string]string{"serveraliveinterval": "30", "user": "postgres", "port": "22"}},
+	}
+	for _, tt := range tests {
+		values := make(map[string]string)
+		if err := readSSHConfig(config, tt.host, values, 0); err != nil {
+			t.Errorf("%s: readSSHConfig() error = %v", tt.host, err)
+			continue
+		}
+		if !reflect.DeepEqual(values, tt.want) {
+			t.Errorf("%s: values = %v, want %v", tt.host, values, tt.want)
+		}
+	}
+
+	if err := readSSHConfig(filepath.Join(dir, "missing"), "prod", map[string]string{}, 0); err == nil {
+		t.Errorf("missing file: no error")
+	}
+}
+
+func TestResolveWithSSHConfig(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config")
+	content := "Host prod\n  HostName %h.example.com\n  Port 2222\n  User deploy\n"
+	if err := os.WriteFile(config, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"alias from config", []string{"ssh", "-F", config, "prod"}, "deploy@prod.example.com:2222 (prod)"},
+		{"command line wins", []string{"ssh", "-F", config, "-p", "2200", "root@prod"}, "root@prod.example.com:2200 (prod)"},
+		{"-o wins over -p", []string{"ssh", "-F", config, "-oPort=2300", "-p", "2200", "prod"}, "deploy@prod.example.com:2300 (prod)"},
+		{"ssh url", []string{"ssh", "-F", config, "ssh://admin@[fd00::1]:2022"}, "admin@[fd00::1]:2022"},
+		{"unknown host", []string{"ssh", "-F", config, "other"}, "other:22"},
+	}
+	for _, tt := range tests {
+		parsed, err := parseSSHArgs(tt.args)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		endpoint, err := resolveWithSSHConfig(parsed)
+		if err != nil {
+			t.Errorf("%s: error = %v", tt.name, err)
+			continue
+		}
+		if endpoint.String() != tt.want {
+			t.Errorf("%s: endpoint = %s, want %s", tt.name, endpoint, tt.want)
+		}
+	}
+}
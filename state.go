@@ -0,0 +1,159 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// TunnelState is a state in the tunnel lifecycle
+type TunnelState string
+
+// Tunnel lifecycle states
+const (
+	StateDisconnected TunnelState = "disconnected" // Not running, or ssh exited cleanly
+	StateWaiting      TunnelState = "waiting"      // A precondition such as a VPN interface is not met yet
+	StateConnecting   TunnelState = "connecting"   // Maintenance loop is establishing the ssh session
+	StateConnected    TunnelState = "connected"    // Local port is forwarded and checks pass
+	StateUnhealthy    TunnelState = "unhealthy"    // Port is forwarded but the application health check fails
+	StateError        TunnelState = "error"        // Last attempt failed, a retry is pending
+	StateHijacked     TunnelState = "hijacked"     // Another process is listening on the local port
+	StateIdle         TunnelState = "idle"         // On-demand tunnel is listening, ssh starts on the first connection
+	StateFailed       TunnelState = "failed"       // Retry attempts exhausted, requires a manual start
+)
+
+// tunnelTransitions lists the states each state may move to
+var tunnelTransitions = map[TunnelState][]TunnelState{
+	StateDisconnected: {StateConnecting, StateWaiting, StateError, StateIdle, StateFailed},
+	StateWaiting:      {StateConnecting, StateError, StateIdle, StateDisconnected, StateFailed},
+	StateConnecting:   {StateConnected, StateWaiting, StateError, StateHijacked, StateIdle, StateDisconnected, StateFailed},
+	StateConnected:    {StateUnhealthy, StateConnecting, StateError, StateHijacked, StateIdle, StateDisconnected},
+	StateUnhealthy:    {StateConnected, StateConnecting, StateError, StateHijacked, StateIdle, StateDisconnected},
+	StateError:        {StateConnecting, StateWaiting, StateError, StateIdle, StateDisconnected, StateFailed},
+	StateHijacked:     {StateConnected, StateConnecting, StateWaiting, StateError, StateIdle, StateDisconnected, StateFailed},
+	StateIdle:         {StateConnecting, StateError, StateDisconnected},
+	StateFailed:       {StateConnecting, StateDisconnected},
+}
+
+// maxTransitionHistory bounds the per-tunnel transition history
+const maxTransitionHistory = 50
+
+// TunnelTransition records a single state change and why it happened. Entries with
+// an Action record something the manager did without changing state, such as
+// reclaiming the local port.
+type TunnelTransition struct {
+	Tunnel string      `json:"tunnel"`
+	From   TunnelState `json:"from"`
+	To     TunnelState `json:"to"`
+	Reason string      `json:"reason"`
+	At     time.Time   `json:"at"`
+
+	Action    string        `json:"action,omitempty"`
+	Processes []PortProcess `json:"processes,omitempty"`
+}
+
+// canTransition reports whether moving from one state to another is legal
+func canTransition(from, to TunnelState) bool {
+	for _, allowed := range tunnelTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// setState moves the tunnel to a new state, records the transition and publishes it.
+// Illegal transitions are logged and ignored. The caller must hold t.mutex.
+func (t *Tunnel) setState(to TunnelState, reason string) bool {
+	from := t.status
+	if from == to && reason == t.stateReason {
+		return true
+	}
+	if from != to && !canTransition(from, to) {
+		log.Printf("Tunnel '%s': ignoring illegal transition %s -> %s (%s)", t.config.Name, from, to, reason)
+		return false
+	}
+
+	t.status = to
+	t.stateReason = reason
+	t.signalReady()
+
+	transition := TunnelTransition{
+		Tunnel: t.config.Name,
+		From:   from,
+		To:     to,
+		Reason: reason,
+		At:     time.Now().UTC(),
+	}
+
+	t.appendHistory(transition)
+
+	// The manager lock comes before t.mutex, so dependents are handled separately
+	if t.manager != nil && cascades(transition) {
+		go t.manager.cascadeDependencies(transition)
+	}
+	return true
+}
+
+// recordEvent adds an action to the history without changing state.
+// The caller must hold t.mutex.
+func (t *Tunnel) recordEvent(action, reason string, processes []PortProcess) {
+	t.appendHistory(TunnelTransition{
+		Tunnel:    t.config.Name,
+		From:      t.status,
+		To:        t.status,
+		Reason:    reason,
+		At:        time.Now().UTC(),
+		Action:    action,
+		Processes: processes,
+	})
+}
+
+// appendHistory stores an entry in the bounded history and publishes it
+func (t *Tunnel) appendHistory(transition TunnelTransition) {
+	t.logTransition(transition)
+
+	t.history = append(t.history, transition)
+	if len(t.history) > maxTransitionHistory {
+		t.history = t.history[len(t.history)-maxTransitionHistory:]
+	}
+
+	if t.manager != nil {
+		t.manager.publishTransition(transition)
+	}
+}
+
+// setError records an error message and moves the tunnel to the given error state.
+// The caller must hold t.mutex.
+func (t *Tunnel) setError(to TunnelState, message string) {
+	t.lastError = message
+	t.setState(to, message)
+}
+
+// History returns a copy of the tunnel's recent transitions, oldest first
+func (t *Tunnel) History() []TunnelTransition {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	history := make([]TunnelTransition, len(t.history))
+	copy(history, t.history)
+	return history
+}
+
+// publishTransition queues a transition for the event loop without blocking the caller
+func (tm *TunnelManager) publishTransition(transition TunnelTransition) {
+	select {
+	case tm.transitions <- transition:
+	default:
+		log.Printf("Transition queue full, dropping event for tunnel '%s'", transition.Tunnel)
+	}
+}
+
+// notifyStatusChanged asks the event loop to broadcast a fresh status snapshot,
+// for changes such as added or deleted tunnels that are not state transitions
+func (tm *TunnelManager) notifyStatusChanged() {
+	select {
+	case tm.statusChanged <- struct{}{}:
+	default:
+		// An update is already pending
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+// TestTransitionPaths walks the state sequences the maintenance loop, the on-demand
+// standby loop and the manager produce, and checks every step is accepted
+func TestTransitionPaths(t *testing.T) {
+	tests := []struct {
+		name string
+		path []TunnelState
+	}{
+		{"connect", []TunnelState{StateDisconnected, StateConnecting, StateConnected}},
+		{"clean exit then reconnect", []TunnelState{StateDisconnected, StateConnecting, StateConnected, StateDisconnected, StateConnecting, StateConnected}},
+		{"clean exit then dependency wait", []TunnelState{StateDisconnected, StateConnecting, StateConnected, StateDisconnected, StateWaiting, StateConnecting}},
+		{"clean exit then precondition wait", []TunnelState{StateConnected, StateDisconnected, StateWaiting, StateConnecting}},
+		{"clean exit then port wait", []TunnelState{StateConnected, StateDisconnected, StateWaiting, StateConnecting}},
+		{"clean exit then network down", []TunnelState{StateConnected, StateDisconnected, StateError, StateConnecting}},
+		{"clean exit then give up", []TunnelState{StateConnected, StateDisconnected, StateFailed}},
+		{"clean exit of an on-demand session", []TunnelState{StateConnected, StateDisconnected, StateIdle}},
+		{"on-demand start and idle", []TunnelState{StateDisconnected, StateConnecting, StateIdle, StateConnecting, StateConnected, StateIdle}},
+		{"retries exhausted", []TunnelState{StateDisconnected, StateConnecting, StateError, StateError, StateFailed}},
+		{"give up while waiting", []TunnelState{StateWaiting, StateFailed}},
+		{"give up while connecting", []TunnelState{StateDisconnected, StateConnecting, StateFailed}},
+		{"waiting for a dependency after an error", []TunnelState{StateError, StateWaiting, StateConnecting}},
+		{"restart after failing", []TunnelState{StateFailed, StateConnecting, StateConnected}},
+		{"health check fails and recovers", []TunnelState{StateConnected, StateUnhealthy, StateConnected}},
+		{"port hijacked and restored", []TunnelState{StateConnected, StateHijacked, StateConnected}},
+		{"restart", []TunnelState{StateConnected, StateConnecting, StateConnected}},
+		{"stop while waiting", []TunnelState{StateWaiting, StateDisconnected}},
+		{"stop while idle", []TunnelState{StateIdle, StateDisconnected}},
+		{"stop after failing", []TunnelState{StateFailed, StateDisconnected}},
+	}
+	for _, tt := range tests {
+		tunnel := &Tunnel{config: TunnelConfig{Name: tt.name}, status: tt.path[0]}
+		for i, to := range tt.path[1:] {
+			from := tunnel.status
+			if !tunnel.setState(to, "test step") {
+				t.Errorf("%s: step %d %s -> %s rejected", tt.name, i+1, from, to)
+				break
+			}
+			if tunnel.status != to {
+				t.Errorf("%s: step %d left the tunnel %s, want %s", tt.name, i+1, tunnel.status, to)
+				break
+			}
+		}
+	}
+}
+
+func TestIllegalTransitions(t *testing.T) {
+	tests := []struct{ from, to TunnelState }{
+		{StateDisconnected, StateConnected},
+		{StateDisconnected, StateUnhealthy},
+		{StateWaiting, StateConnected},
+		{StateIdle, StateConnected},
+		{StateFailed, StateError},
+		{StateFailed, StateWaiting},
+	}
+	for _, tt := range tests {
+		tunnel := &Tunnel{config: TunnelConfig{Name: "test"}, status: tt.from}
+		if tunnel.setState(tt.to, "test step") || tunnel.status != tt.from {
+			t.Errorf("%s -> %s was accepted", tt.from, tt.to)
+		}
+	}
+}
+
+func TestTransitionHistory(t *testing.T) {
+	tunnel := &Tunnel{config: TunnelConfig{Name: "test"}, status: StateDisconnected}
+	tunnel.setState(StateConnecting, "start requested")
+	tunnel.setState(StateConnecting, "start requested") // Repeats are not recorded
+	tunnel.recordEvent("port-reclaimed", "killed 42", nil)
+
+	history := tunnel.History()
+	if len(history) != 2 {
+		t.Fatalf("history has %d entries, want 2: %+v", len(history), history)
+	}
+	if history[0].From != StateDisconnected || history[0].To != StateConnecting || history[0].Reason != "start requested" {
+		t.Errorf("first entry %+v", history[0])
+	}
+	if history[1].Action != "port-reclaimed" || history[1].From != history[1].To {
+		t.Errorf("second entry %+v", history[1])
+	}
+
+	for i := 0; i < maxTransitionHistory+10; i++ {
+		tunnel.recordEvent("test", "bounded", nil)
+	}
+	if n := len(tunnel.History()); n != maxTransitionHistory {
+		t.Errorf("history has %d entries, want %d", n, maxTransitionHistory)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Clock watch tuning. Checks are cheap, so run them often enough that a resume is
+// noticed within seconds; the threshold leaves room for scheduling delays and NTP slew.
+const (
+	clockCheckInterval = 5 * time.Second
+	clockJumpThreshold = 15 * time.Second
+)
+
+// watchClock detects suspend/resume by comparing wall-clock time with monotonic time.
+// The monotonic clock stops while the machine sleeps but the wall clock keeps going,
+// so after a resume the wall clock has advanced much further than the monotonic one.
+func (tm *TunnelManager) watchClock(ctx context.Context) {
+	ticker := time.NewTicker(clockCheckInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		monotonic := now.Sub(last)
+		wall := now.Round(0).Sub(last.Round(0)) // Round(0) strips the monotonic reading
+		last = now
+
+		drift := wall - monotonic
+		switch {
+		case drift > clockJumpThreshold:
+			tm.onResume(drift)
+		case drift < -clockJumpThreshold:
+			// The clock was set back; sessions are unaffected, but say so for diagnostics
+			log.Printf("Wall clock jumped back by %s", (-drift).Round(time.Second))
+			tm.BroadcastSSE("clock_jump", map[string]interface{}{
+				"drift":     drift.Round(time.Second).String(),
+				"timestamp": time.Now().UTC(),
+			})
+		}
+	}
+}
+
+// onResume restarts every live tunnel after the machine wakes up. ssh sessions that
+// were open across a suspend are usually half-dead and would otherwise linger until
+// ServerAliveCountMax expires, while the local listener keeps passing health checks.
+func (tm *TunnelManager) onResume(slept time.Duration) {
+	const reason = "resumed from suspend"
+
+	log.Printf("Resume detected (wall clock ran %s ahead of monotonic clock) - restarting tunnels", slept.Round(time.Second))
+
+	tm.BroadcastSSE("system_resume", map[string]interface{}{
+		"slept":     slept.Round(time.Second).String(),
+		"timestamp": time.Now().UTC(),
+	})
+
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	for _, tunnel := range tm.tunnels {
+		tunnel.mutex.RLock()
+		enabled := tunnel.config.Enabled
+		live := tunnel.status == StateConnected || tunnel.status == StateUnhealthy || tunnel.status == StateConnecting
+		tunnel.mutex.RUnlock()
+
+		if !enabled {
+			continue
+		}
+		if live {
+			go tunnel.Restart(reason)
+		} else {
+			tunnel.Wake(reason)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Bulk actions applied to every tunnel matching a selector
+const (
+	BulkStart   = "start"
+	BulkStop    = "stop"
+	BulkRestart = "restart"
+)
+
+// validateTags checks that tags can be used in a selector
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if tag == "" {
+			return fmt.Errorf("tags must not be empty")
+		}
+		if strings.ContainsAny(tag, ", \t") {
+			return fmt.Errorf("tag %q must not contain commas or whitespace", tag)
+		}
+	}
+	return nil
+}
+
+// TunnelSelector picks tunnels by name, tag or state. A tunnel matches when it
+// matches any of the values given for each field; empty fields match everything.
+type TunnelSelector struct {
+	Names  []string      `json:"names,omitempty"`
+	Tags   []string      `json:"tags,omitempty"`
+	Status []TunnelState `json:"status,omitempty"`
+}
+
+// parseSelector reads a selector from the name, tag and status query parameters,
+// each of which may be repeated or hold a comma-separated list
+func parseSelector(query url.Values) (TunnelSelector, error) {
+	list := func(key string) []string {
+		var values []string
+		for _, value := range query[key] {
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					values = append(values, item)
+				}
+			}
+		}
+		return values
+	}
+
+	selector := TunnelSelector{Names: list("name"), Tags: list("tag")}
+	for _, status := range list("status") {
+		state := TunnelState(status)
+		if _, known := tunnelTransitions[state]; !known {
+			return selector, fmt.Errorf("unknown status %q", status)
+		}
+		selector.Status = append(selector.Status, state)
+	}
+	return selector, nil
+}
+
+// empty reports whether the selector matches every tunnel
+func (s TunnelSelector) empty() bool {
+	return len(s.Names) == 0 && len(s.Tags) == 0 && len(s.Status) == 0
+}
+
+// matches reports whether a tunnel with the given name, tags and state is selected
+func (s TunnelSelector) matches(name string, tags []string, status TunnelState) bool {
+	return matchesAny(s.Names, name) && s.matchesTags(tags) && matchesAny(s.Status, status)
+}
+
+// matchesTags reports whether tags contain one of the selected tags
+func (s TunnelSelector) matchesTags(tags []string) bool {
+	if len(s.Tags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		if matchesAny(s.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether value is in values, or values is empty
+func matchesAny[T comparable](values []T, value T) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// filterStatuses returns the statuses of the selected tunnels
+func filterStatuses(statuses []TunnelStatus, selector TunnelSelector) []TunnelStatus {
+	if selector.empty() {
+		return statuses
+	}
+	filtered := []TunnelStatus{}
+	for _, status := range statuses {
+		if selector.matches(status.Config.Name, status.Config.Tags, status.Status) {
+			filtered = append(filtered, status)
+		}
+	}
+	return filtered
+}
+
+// BulkAction starts, stops or restarts every tunnel matching the selector and
+// returns the names of the tunnels it acted on. Starting and stopping persist the
+// enabled flag like a toggle; restarting leaves disabled tunnels alone.
+func (tm *TunnelManager) BulkAction(action string, selector TunnelSelector) ([]string, error) {
+	switch action {
+	case BulkStart, BulkStop, BulkRestart:
+	default:
+		return nil, fmt.Errorf("unknown action %q", action)
+	}
+	if selector.empty() {
+		return nil, fmt.Errorf("a name, tag or status selector is required")
+	}
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	// Tunnels start concurrently; waitForDependencies holds dependents until their
+	// dependencies connect, and the order only keeps the reported names stable
+	names := []string{}
+	changed := false
+	for _, tunnel := range tm.startOrder() {
+		tunnel.mutex.RLock()
+		selected := selector.matches(tunnel.config.Name, tunnel.config.Tags, tunnel.status)
+		enabled := tunnel.config.Enabled
+		running := tunnel.cancel != nil
+		tunnel.mutex.RUnlock()
+
+		if !selected {
+			continue
+		}
+
+		switch action {
+		case BulkStart:
+			enabledNow := tunnel.setEnabled(true)
+			if !enabledNow && running {
+				continue
+			}
+			changed = changed || enabledNow
+			go tunnel.Start()
+		case BulkStop:
+			if !tunnel.setEnabled(false) {
+				continue
+			}
+			changed = true
+			tunnel.Stop()
+		case BulkRestart:
+			if !enabled {
+				continue
+			}
+			// A failed tunnel has no maintenance loop left to restart
+			if running {
+				go tunnel.Restart("bulk restart")
+			} else {
+				go tunnel.Start()
+			}
+		}
+		names = append(names, tunnel.config.Name)
+	}
+
+	if changed {
+		tm.saveConfig()
+		tm.notifyStatusChanged()
+	}
+	log.Printf("Bulk %s applied to %d tunnel(s): %s", action, len(names), strings.Join(names, ", "))
+	return names, nil
+}
+
+// sseFilter limits an event stream to the selected tunnels. It remembers the tags
+// and state of every tunnel from status snapshots, so events that only name a
+// tunnel can be filtered too.
+type sseFilter struct {
+	selector TunnelSelector
+	tags     map[string][]string
+	states   map[string]TunnelState
+}
+
+// newSSEFilter returns a filter for the selector, or nil if it selects everything
+func newSSEFilter(selector TunnelSelector) *sseFilter {
+	if selector.empty() {
+		return nil
+	}
+	return &sseFilter{
+		selector: selector,
+		tags:     make(map[string][]string),
+		states:   make(map[string]TunnelState),
+	}
+}
+
+// apply returns the part of an event the client should see, or false to skip it.
+// Events that are not about a tunnel, such as network changes, always pass.
+func (f *sseFilter) apply(data interface{}) (interface{}, bool) {
+	switch data := data.(type) {
+	case []TunnelStatus:
+		f.tags = make(map[string][]string, len(data))
+		for _, status := range data {
+			f.tags[status.Config.Name] = status.Config.Tags
+			f.states[status.Config.Name] = status.Status
+		}
+		return filterStatuses(data, f.selector), true
+	case TunnelTransition:
+		f.states[data.Tunnel] = data.To
+		return data, f.selects(data.Tunnel)
+	case map[string]interface{}:
+		if name, ok := data["tunnel"].(string); ok {
+			return data, f.selects(name)
+		}
+	}
+	return data, true
+}
+
+// selects reports whether the named tunnel was selected when last seen
+func (f *sseFilter) selects(name string) bool {
+	tags, known := f.tags[name]
+	return known && f.selector.matches(name, tags, f.states[name])
+}
+
+// encodeSSE formats an event as the JSON payload of an SSE message
+func encodeSSE(eventType string, data interface{}) string {
+	eventData, _ := json.Marshal(map[string]interface{}{
+		"type":      eventType,
+		"data":      data,
+		"timestamp": time.Now().UTC(),
+	})
+	return string(eventData)
+}
+
+// FilterSSE applies a client's filter to an event sent only to that client, such as
+// the initial status snapshot
+func (tm *TunnelManager) FilterSSE(client chan string, eventType string, data interface{}) (string, bool) {
+	tm.sseMutex.Lock()
+	defer tm.sseMutex.Unlock()
+
+	if filter := tm.sseClients[client]; filter != nil {
+		var ok bool
+		if data, ok = filter.apply(data); !ok {
+			return "", false
+		}
+	}
+	return encodeSSE(eventType, data), true
+}
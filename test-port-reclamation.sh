@@ -83,7 +83,7 @@ test_reclamation() {
     
     # Build the test binary
     echo "   Building test binary..."
-    go build -o easytunnel-test main.go
+    go build -o easytunnel-test .
     
     # Create a test tunnel configuration that uses the occupied port
     echo "   Creating test tunnel with port $TEST_PORT..."